@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// access log formats
+const (
+	LogCommon   = "common"   // NCSA common log format
+	LogCombined = "combined" // common log format with referer and user agent
+	LogJSON     = "json"     // one json object per line
+	LogOff      = "off"
+)
+
+// AccessLog writes a line for each request in given format. Common and
+// combined lines are followed by latency in milliseconds and request id.
+type AccessLog struct {
+	format string
+
+	lck sync.Mutex
+	out io.Writer
+}
+
+// NewAccessLog create access log writing to out
+func NewAccessLog(format string, out io.Writer) (*AccessLog, error) {
+	switch format {
+	case LogCommon, LogCombined, LogJSON, LogOff:
+	case "":
+		format = LogOff
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+	return &AccessLog{format: format, out: out}, nil
+}
+
+// accessEntry is the json format of access log
+type accessEntry struct {
+	Time      string  `json:"time"`
+	Remote    string  `json:"remote"`
+	User      string  `json:"user,omitempty"`
+	Method    string  `json:"method"`
+	URI       string  `json:"uri"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Bytes     int64   `json:"bytes"`
+	Latency   float64 `json:"latency_ms"`
+	RequestID string  `json:"request_id,omitempty"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"user_agent,omitempty"`
+}
+
+// Log write access log of request
+func (l *AccessLog) Log(r *http.Request, status int, size int64, latency time.Duration) {
+	if l == nil || l.format == LogOff {
+		return
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	user, _, _ := r.BasicAuth()
+	now := time.Now()
+	ms := float64(latency.Microseconds()) / 1000
+
+	var buf bytes.Buffer
+	if l.format == LogJSON {
+		json.NewEncoder(&buf).Encode(&accessEntry{
+			Time:      now.Format(time.RFC3339Nano),
+			Remote:    remote,
+			User:      user,
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Proto:     r.Proto,
+			Status:    status,
+			Bytes:     size,
+			Latency:   ms,
+			RequestID: RequestID(r),
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		})
+	} else {
+		// 127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+		fmt.Fprintf(&buf, "%s - %s [%s] %s %d %s",
+			remote,
+			dash(user),
+			now.Format("02/Jan/2006:15:04:05 -0700"),
+			strconv.Quote(r.Method+" "+r.RequestURI+" "+r.Proto),
+			status,
+			dash(sizeString(size)))
+		if l.format == LogCombined {
+			fmt.Fprintf(&buf, " %s %s", strconv.Quote(r.Referer()), strconv.Quote(r.UserAgent()))
+		}
+		fmt.Fprintf(&buf, " %.3f %s\n", ms, dash(RequestID(r)))
+	}
+
+	l.lck.Lock()
+	l.out.Write(buf.Bytes())
+	l.lck.Unlock()
+}
+
+func sizeString(size int64) string {
+	if size == 0 {
+		return ""
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"./chat"
+	"./logging"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ClientInfo is a connected client seen by admins
+type ClientInfo struct {
+	ID        uint64    `json:"id"`              // client id
+	User      string    `json:"user,omitempty"`  // user name claimed in messages
+	Addr      string    `json:"addr"`            // remote address
+	Connected time.Time `json:"connected"`       // connect time
+	Queue     int       `json:"queue"`           // messages waiting to be sent
+	Rooms     []string  `json:"rooms,omitempty"` // ids of joined rooms
+}
+
+// RoomDetail is a room with its online clients
+type RoomDetail struct {
+	*chat.RoomInfo
+	Clients []*ClientInfo `json:"clients"`
+}
+
+func clientInfo(c *chat.Client) *ClientInfo {
+	return &ClientInfo{
+		ID:        c.ID(),
+		User:      c.User(),
+		Addr:      c.RemoteAddr(),
+		Connected: c.Created(),
+		Queue:     c.QueueLen(),
+		Rooms:     c.Rooms(),
+	}
+}
+
+func clientInfos(clients []*chat.Client) []*ClientInfo {
+	res := make([]*ClientInfo, 0, len(clients))
+	for _, c := range clients {
+		res = append(res, clientInfo(c))
+	}
+	return res
+}
+
+// writeJSON write v as json response with status code
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError write {"error": msg} with status code
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// HealthHandle report the process is alive
+func HealthHandle(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyHandle report whether hub serves and its store is reachable
+func ReadyHandle(hub *chat.RoomHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := hub.Ready(); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// AdminAuth allow requests with header "Authorization: Bearer <token>"
+// or basic auth of admin user, all requests are refused if neither is set.
+func AdminAuth(cfg AdminConfig, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Token == "" && cfg.User == "" {
+			writeError(w, http.StatusForbidden, "admin disabled, set admin token or user to enable it")
+			return
+		}
+		if auth := r.Header.Get("Authorization"); cfg.Token != "" && strings.HasPrefix(auth, "Bearer ") {
+			if secureEqual(strings.TrimPrefix(auth, "Bearer "), cfg.Token) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		} else if user, password, ok := r.BasicAuth(); ok && cfg.User != "" {
+			// check both, so timing tells nothing about which one is wrong
+			if secureEqual(user, cfg.User) && secureEqual(password, cfg.Password) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if cfg.User != "" {
+			w.Header().Add("WWW-Authenticate", `Basic realm="sparrow admin"`)
+		}
+		if cfg.Token != "" {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="sparrow admin"`)
+		}
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+	})
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// adminToken return token in file, the file is created with a random
+// token if it doesn't exist.
+func adminToken(file string) (string, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err == nil {
+		if token := strings.TrimSpace(string(bs)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	buf := make([]byte, 24)
+	if _, err = rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err = ioutil.WriteFile(file, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// NewAdminRouter return router of the admin listener, serving pprof,
+// expvar, prometheus metrics, log levels and admin api.
+func NewAdminRouter(hub *chat.RoomHub, metrics prometheus.Gatherer) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", HealthHandle)
+	r.HandleFunc("/readyz", ReadyHandle(hub))
+	r.Handle("/metrics", promhttp.HandlerFor(metrics, promhttp.HandlerOpts{}))
+	r.Handle("/debug/vars", expvar.Handler())
+	r.Handle("/debug/log/levels", logging.LevelHandler())
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// index serves named profiles too, e.g. /debug/pprof/heap
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+	r.PathPrefix("/admin/api/").Handler(AdminAPI("/admin/api", hub))
+	return r
+}
+
+// AdminAPI serve introspection of hub under prefix:
+//
+//	GET    /rooms                 rooms
+//	GET    /rooms/{room}          room with its clients
+//	DELETE /rooms/{room}          close room
+//	GET    /rooms/{room}/clients  clients in room
+//	GET    /clients               connected clients
+//	GET    /clients/{id}          client
+//	DELETE /clients/{id}          disconnect client
+func AdminAPI(prefix string, hub *chat.RoomHub) http.Handler {
+	// full paths, method mismatches are lost in subrouters
+	api := mux.NewRouter()
+
+	api.HandleFunc(prefix+"/rooms", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, hub.RoomList())
+	}).Methods(http.MethodGet)
+
+	api.HandleFunc(prefix+"/rooms/{room}", func(w http.ResponseWriter, r *http.Request) {
+		room := hub.GetRoom(mux.Vars(r)["room"])
+		if room == nil {
+			writeError(w, http.StatusNotFound, chat.ErrRoomNotFound.Error())
+			return
+		}
+		clients, _ := hub.RoomClients(room.ID)
+		writeJSON(w, http.StatusOK, &RoomDetail{RoomInfo: room, Clients: clientInfos(clients)})
+	}).Methods(http.MethodGet)
+
+	api.HandleFunc(prefix+"/rooms/{room}", func(w http.ResponseWriter, r *http.Request) {
+		room := hub.DeleteRoom(mux.Vars(r)["room"])
+		if room == nil {
+			writeError(w, http.StatusNotFound, chat.ErrRoomNotFound.Error())
+			return
+		}
+		mainLog.Info("room closed by admin", "room", room.ID, "name", room.Name, "remote", r.RemoteAddr)
+		writeJSON(w, http.StatusOK, room)
+	}).Methods(http.MethodDelete)
+
+	api.HandleFunc(prefix+"/rooms/{room}/clients", func(w http.ResponseWriter, r *http.Request) {
+		clients, err := hub.RoomClients(mux.Vars(r)["room"])
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, clientInfos(clients))
+	}).Methods(http.MethodGet)
+
+	api.HandleFunc(prefix+"/clients", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, clientInfos(hub.Clients()))
+	}).Methods(http.MethodGet)
+
+	client := func(w http.ResponseWriter, r *http.Request) *chat.Client {
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid client id")
+			return nil
+		}
+		c := hub.GetClient(id)
+		if c == nil {
+			writeError(w, http.StatusNotFound, "client not found")
+		}
+		return c
+	}
+	api.HandleFunc(prefix+"/clients/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if c := client(w, r); c != nil {
+			writeJSON(w, http.StatusOK, clientInfo(c))
+		}
+	}).Methods(http.MethodGet)
+
+	api.HandleFunc(prefix+"/clients/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if c := client(w, r); c != nil {
+			c.Close()
+			mainLog.Info("client disconnected by admin", "client", c.ID(), "user", c.User(), "remote", r.RemoteAddr)
+			writeJSON(w, http.StatusOK, clientInfo(c))
+		}
+	}).Methods(http.MethodDelete)
+
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not found")
+	})
+	api.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+	return api
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"./chat"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func adminRequest(h http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminAPI(t *testing.T) {
+	hub := chat.NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(hub.ServeWebsocket))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	var reply chat.Message
+	assert.NoError(t, conn.WriteJSON(&chat.Message{Type: chat.T_JOIN, From: "alice", Room: "general"}))
+	for reply.Type != chat.T_JOIN {
+		assert.NoError(t, conn.ReadJSON(&reply))
+	}
+
+	h := AdminAuth(AdminConfig{Token: "secret"}, AdminAPI("/admin/api", hub))
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(h, http.MethodGet, "/admin/api/rooms", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(h, http.MethodGet, "/admin/api/rooms", "wrong").Code)
+	assert.Equal(t, http.StatusForbidden, adminRequest(AdminAuth(AdminConfig{}, h), http.MethodGet, "/admin/api/rooms", "").Code)
+
+	w := adminRequest(h, http.MethodGet, "/admin/api/rooms", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rooms []chat.RoomInfo
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rooms))
+	if assert.Len(t, rooms, 1) {
+		assert.Equal(t, rm.ID, rooms[0].ID)
+	}
+
+	w = adminRequest(h, http.MethodGet, "/admin/api/rooms/general", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var detail struct {
+		ID      string        `json:"id"`
+		Clients []*ClientInfo `json:"clients"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &detail))
+	assert.Equal(t, rm.ID, detail.ID)
+	if !assert.Len(t, detail.Clients, 1) {
+		return
+	}
+	c := detail.Clients[0]
+	assert.Equal(t, "alice", c.User)
+	assert.NotEmpty(t, c.Addr)
+	assert.WithinDuration(t, time.Now(), c.Connected, time.Minute)
+	assert.Equal(t, []string{rm.ID}, c.Rooms)
+
+	assert.Equal(t, http.StatusNotFound, adminRequest(h, http.MethodGet, "/admin/api/rooms/random/clients", "secret").Code)
+	assert.Equal(t, http.StatusBadRequest, adminRequest(h, http.MethodGet, "/admin/api/clients/abc", "secret").Code)
+	assert.Equal(t, http.StatusNotFound, adminRequest(h, http.MethodGet, "/admin/api/clients/18446744073709551615", "secret").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, adminRequest(h, http.MethodPost, "/admin/api/clients", "secret").Code)
+
+	// close room, then disconnect client
+	assert.Equal(t, http.StatusOK, adminRequest(h, http.MethodDelete, "/admin/api/rooms/general", "secret").Code)
+	assert.Nil(t, hub.GetRoom("general"))
+	for reply.Type != chat.T_CLOSE {
+		assert.NoError(t, conn.ReadJSON(&reply))
+	}
+
+	path := "/admin/api/clients/" + strconv.FormatUint(c.ID, 10)
+	assert.Equal(t, http.StatusOK, adminRequest(h, http.MethodDelete, path, "secret").Code)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		return adminRequest(h, http.MethodGet, path, "secret").Code == http.StatusNotFound
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestAdminRouter(t *testing.T) {
+	hub := chat.NewChatHub()
+	h := AdminAuth(AdminConfig{User: "admin", Password: "pass", Token: "secret"}, NewAdminRouter(hub, prometheus.NewRegistry()))
+
+	basic := func(user, password, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.SetBasicAuth(user, password)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, basic("admin", "pass", "/debug/pprof/"))
+	assert.Equal(t, http.StatusOK, basic("admin", "pass", "/debug/pprof/heap"))
+	assert.Equal(t, http.StatusOK, basic("admin", "pass", "/debug/vars"))
+	assert.Equal(t, http.StatusOK, basic("admin", "pass", "/admin/api/rooms"))
+	assert.Equal(t, http.StatusUnauthorized, basic("admin", "secret", "/metrics"))
+	assert.Equal(t, http.StatusUnauthorized, basic("root", "pass", "/metrics"))
+
+	w := adminRequest(h, http.MethodGet, "/metrics", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = adminRequest(h, http.MethodGet, "/debug/log/levels", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, []string{`Basic realm="sparrow admin"`, `Bearer realm="sparrow admin"`}, w.Header().Values("WWW-Authenticate"))
+}
+
+func TestAdminToken(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "admin.token")
+	token, err := adminToken(file)
+	assert.NoError(t, err)
+	assert.Len(t, token, 48)
+	fi, err := os.Stat(file)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	// kept across restarts
+	again, err := adminToken(file)
+	assert.NoError(t, err)
+	assert.Equal(t, token, again)
+}
+
+func TestHealth(t *testing.T) {
+	dir := t.TempDir()
+	store, err := chat.NewFileStore(filepath.Join(dir, "data", "rooms.json"))
+	assert.NoError(t, err)
+	hub := chat.NewChatHub()
+	hub.SetStore(store)
+
+	w := httptest.NewRecorder()
+	HealthHandle(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// store directory is missing
+	ready := ReadyHandle(hub)
+	w = httptest.NewRecorder()
+	ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "store")
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "data"), 0755))
+	w = httptest.NewRecorder()
+	ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	hub.Close()
+	w = httptest.NewRecorder()
+	ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), chat.ErrHubClosed.Error())
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"./chat"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	apiMaxBody      = 64 << 10
+	apiDefaultLimit = 50
+	apiMaxLimit     = 500
+)
+
+// API is the REST api of rooms and messages for bots and scripts,
+// requests need "Authorization: Bearer <token>" of one of api tokens.
+type API struct {
+	hub  *chat.RoomHub
+	site *Site
+}
+
+// NewAPI create REST api of hub, tokens are read from site config,
+// so they are reloaded with it.
+func NewAPI(hub *chat.RoomHub, site *Site) *API {
+	return &API{hub: hub, site: site}
+}
+
+// NewRoomRequest is the body of POST /api/rooms
+type NewRoomRequest struct {
+	Name  string `json:"name"`            // room name
+	Owner string `json:"owner,omitempty"` // user owns the room, none if empty
+}
+
+// PostMessageRequest is the body of POST /api/rooms/{id}/messages
+type PostMessageRequest struct {
+	From string `json:"from,omitempty"` // sender shown to users, "api" if empty
+	Data string `json:"data"`           // message text
+}
+
+// Register mount api routes under /api:
+//
+//	GET    /api/rooms
+//	POST   /api/rooms
+//	GET    /api/rooms/{id}
+//	DELETE /api/rooms/{id}
+//	GET    /api/rooms/{id}/messages?before=&limit=
+//	POST   /api/rooms/{id}/messages
+func (a *API) Register(r *mux.Router) {
+	handle := func(path string, fn http.HandlerFunc, method string) {
+		r.Handle(path, a.auth(fn)).Methods(method)
+	}
+	handle("/api/rooms", a.listRooms, http.MethodGet)
+	handle("/api/rooms", a.createRoom, http.MethodPost)
+	handle("/api/rooms/{id}", a.getRoom, http.MethodGet)
+	handle("/api/rooms/{id}", a.deleteRoom, http.MethodDelete)
+	handle("/api/rooms/{id}/messages", a.listMessages, http.MethodGet)
+	handle("/api/rooms/{id}/messages", a.postMessage, http.MethodPost)
+
+	// json errors for api paths, other paths keep the router's behavior
+	notFound := r.NotFoundHandler
+	if notFound == nil {
+		notFound = http.NotFoundHandler()
+	}
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/api/") {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		notFound.ServeHTTP(w, req)
+	})
+	notAllowed := r.MethodNotAllowedHandler
+	if notAllowed == nil {
+		notAllowed = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		})
+	}
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/api/") {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		notAllowed.ServeHTTP(w, req)
+	})
+}
+
+// auth check bearer token against api tokens
+func (a *API) auth(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens := a.site.Config().API.Tokens
+		if len(tokens) == 0 {
+			writeError(w, http.StatusForbidden, "api disabled, set api tokens to enable it")
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") {
+			token := strings.TrimPrefix(auth, "Bearer ")
+			for _, t := range tokens {
+				if secureEqual(token, t) {
+					h(w, r)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="sparrow api"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+	})
+}
+
+// apiError write error with status code matching it
+func apiError(w http.ResponseWriter, err error) {
+	writeError(w, chat.StatusCode(err), err.Error())
+}
+
+// decode json body into v, write error and return false if it's invalid
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBody)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func (a *API) listRooms(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.hub.RoomList())
+}
+
+func (a *API) createRoom(w http.ResponseWriter, r *http.Request) {
+	var req NewRoomRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	room, err := a.hub.NewRoom(req.Name, req.Owner)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, room)
+}
+
+func (a *API) getRoom(w http.ResponseWriter, r *http.Request) {
+	room := a.hub.GetRoom(mux.Vars(r)["id"])
+	if room == nil {
+		apiError(w, chat.ErrRoomNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, room)
+}
+
+func (a *API) deleteRoom(w http.ResponseWriter, r *http.Request) {
+	room := a.hub.DeleteRoom(mux.Vars(r)["id"])
+	if room == nil {
+		apiError(w, chat.ErrRoomNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, room)
+}
+
+func (a *API) listMessages(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var (
+		before uint64
+		limit  = apiDefaultLimit
+		err    error
+	)
+	if v := q.Get("before"); v != "" {
+		if before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid before, want message id")
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > apiMaxLimit {
+			writeError(w, http.StatusBadRequest, "limit must be 1 to "+strconv.Itoa(apiMaxLimit))
+			return
+		}
+	}
+
+	msgs, err := a.hub.History(mux.Vars(r)["id"], before, limit)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, msgs)
+}
+
+func (a *API) postMessage(w http.ResponseWriter, r *http.Request) {
+	var req PostMessageRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.From == "" {
+		req.From = "api"
+	}
+	msg, err := a.hub.Post(mux.Vars(r)["id"], req.From, req.Data)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, msg)
+}
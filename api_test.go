@@ -0,0 +1,109 @@
+package main
+
+import (
+	"./chat"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func apiRequest(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer bot")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAPI(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.API.Tokens = []string{"ci", "bot"}
+	hub := chat.NewChatHub()
+	r := mux.NewRouter()
+	r.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet)
+	NewAPI(hub, NewSite(cfg)).Register(r)
+
+	// auth
+	req := httptest.NewRequest(http.MethodGet, "/api/rooms", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"error": "unauthorized"}`, w.Body.String())
+
+	w = apiRequest(r, http.MethodPost, "/api/rooms", `{"name": "General"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var room chat.RoomInfo
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &room))
+	assert.Equal(t, "general", room.Slug)
+
+	assert.Equal(t, http.StatusConflict, apiRequest(r, http.MethodPost, "/api/rooms", `{"name": "general"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, apiRequest(r, http.MethodPost, "/api/rooms", `{"name": "?"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, apiRequest(r, http.MethodPost, "/api/rooms", `name=general`).Code)
+	assert.Equal(t, http.StatusOK, apiRequest(r, http.MethodGet, "/api/rooms/general", "").Code)
+	assert.Equal(t, http.StatusNotFound, apiRequest(r, http.MethodGet, "/api/rooms/random", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, apiRequest(r, http.MethodPut, "/api/rooms/general", "").Code)
+	// other paths keep the router's behavior
+	w = apiRequest(r, http.MethodPut, "/ping", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Empty(t, w.Body.String())
+	w = apiRequest(r, http.MethodGet, "/api/nothing", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+
+	w = apiRequest(r, http.MethodGet, "/api/rooms", "")
+	var rooms []chat.RoomInfo
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rooms))
+	assert.Len(t, rooms, 1)
+
+	// messages
+	var ids []uint64
+	for _, text := range []string{"build #1 passed", "build #2 failed", "build #3 passed"} {
+		w = apiRequest(r, http.MethodPost, "/api/rooms/general/messages", `{"from": "ci", "data": "`+text+`"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var msg chat.Message
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &msg))
+		assert.NotZero(t, msg.ID)
+		assert.Equal(t, room.ID, msg.Room)
+		ids = append(ids, msg.ID)
+	}
+	assert.Equal(t, http.StatusBadRequest, apiRequest(r, http.MethodPost, "/api/rooms/general/messages", `{}`).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge,
+		apiRequest(r, http.MethodPost, "/api/rooms/general/messages", `{"data": "`+strings.Repeat("x", 1024)+`"}`).Code)
+
+	var msgs []chat.Message
+	assert.Eventually(t, func() bool {
+		w = apiRequest(r, http.MethodGet, "/api/rooms/general/messages", "")
+		return json.Unmarshal(w.Body.Bytes(), &msgs) == nil && len(msgs) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "ci", msgs[0].From)
+
+	w = apiRequest(r, http.MethodGet, "/api/rooms/general/messages?limit=1&before="+strconv.FormatUint(ids[2], 10), "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &msgs))
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "build #2 failed", msgs[0].Data)
+	}
+	assert.Equal(t, http.StatusBadRequest, apiRequest(r, http.MethodGet, "/api/rooms/general/messages?limit=0", "").Code)
+	assert.Equal(t, http.StatusBadRequest, apiRequest(r, http.MethodGet, "/api/rooms/general/messages?before=x", "").Code)
+
+	// discarded by handlers
+	hub.Use(chat.PriorityNormal, func(next chat.HandlerFunc) chat.HandlerFunc {
+		return func(ctx *chat.Context) error { return chat.ErrDiscard }
+	})
+	assert.Equal(t, http.StatusForbidden, apiRequest(r, http.MethodPost, "/api/rooms/general/messages", `{"data": "hi"}`).Code)
+
+	assert.Equal(t, http.StatusOK, apiRequest(r, http.MethodDelete, "/api/rooms/general", "").Code)
+	assert.Equal(t, http.StatusNotFound, apiRequest(r, http.MethodDelete, "/api/rooms/general", "").Code)
+
+	// disabled without tokens
+	cfg.API.Tokens = nil
+	r = mux.NewRouter()
+	NewAPI(hub, NewSite(cfg)).Register(r)
+	assert.Equal(t, http.StatusForbidden, apiRequest(r, http.MethodGet, "/api/rooms", "").Code)
+}
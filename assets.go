@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// web UI bundled into the binary
+//
+//go:embed public
+var embedded embed.FS
+
+// files smaller than this are not worth compressing
+const minCompressSize = 512
+
+// Assets serves web files embedded in the binary, files in the override
+// directory replace the embedded ones with the same path.
+// Files are served with ETag and Last-Modified, compressed with brotli
+// or gzip if client accepts, precompressed .br and .gz siblings are used
+// when they exist, otherwise files are compressed once and cached.
+type Assets struct {
+	override string
+	fs       fs.FS
+	started  time.Time // Last-Modified of embedded files
+
+	lck   sync.Mutex
+	cache map[string]*asset
+}
+
+// asset is a cached file
+type asset struct {
+	modTime time.Time
+	etag    string
+	data    []byte
+	br, gz  []byte // compressed data, nil if not compressible
+}
+
+// NewAssets create assets overridden by files in dir, dir may be empty
+func NewAssets(dir string) (*Assets, error) {
+	sub, err := fs.Sub(embedded, "public")
+	if err != nil {
+		return nil, err
+	}
+	a := &Assets{
+		override: dir,
+		fs:       sub,
+		started:  time.Now(),
+		cache:    make(map[string]*asset),
+	}
+	if dir != "" {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return nil, errors.New("override directory " + dir + " not found")
+		}
+		a.fs = overlayFS{os.DirFS(dir), sub}
+	}
+	return a, nil
+}
+
+// overlayFS opens files in upper, then in lower
+type overlayFS struct {
+	upper, lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if f, err := o.upper.Open(name); err == nil {
+		return f, nil
+	}
+	return o.lower.Open(name)
+}
+
+// ReadFile return content of file name
+func (a *Assets) ReadFile(name string) ([]byte, error) {
+	f, err := a.load(name)
+	if err != nil {
+		return nil, err
+	}
+	return f.data, nil
+}
+
+// load file, cached until the file is modified
+func (a *Assets) load(name string) (*asset, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	f, err := a.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	modTime := fi.ModTime()
+	if modTime.IsZero() {
+		modTime = a.started
+	}
+
+	a.lck.Lock()
+	cached, ok := a.cache[name]
+	a.lck.Unlock()
+	if ok && cached.modTime.Equal(modTime) {
+		return cached, nil
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	res := &asset{
+		modTime: modTime,
+		etag:    `"` + hex.EncodeToString(sum[:8]) + `"`,
+		data:    data,
+	}
+	if compressible(name, data) {
+		res.br = a.precompressed(name+".br", modTime)
+		if res.br == nil {
+			res.br = compress(data, "br")
+		}
+		res.gz = a.precompressed(name+".gz", modTime)
+		if res.gz == nil {
+			res.gz = compress(data, "gzip")
+		}
+	}
+
+	a.lck.Lock()
+	a.cache[name] = res
+	a.lck.Unlock()
+	return res, nil
+}
+
+// precompressed return content of compressed sibling, if it's not older
+// than the file.
+func (a *Assets) precompressed(name string, modTime time.Time) []byte {
+	f, err := a.fs.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || (!fi.ModTime().IsZero() && fi.ModTime().Before(modTime)) {
+		return nil
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func compressible(name string, data []byte) bool {
+	if len(data) < minCompressSize {
+		return false
+	}
+	typ := mime.TypeByExtension(filepath.Ext(name))
+	if typ == "" {
+		typ = http.DetectContentType(data)
+	}
+	return strings.HasPrefix(typ, "text/") ||
+		strings.Contains(typ, "javascript") ||
+		strings.Contains(typ, "json") ||
+		strings.Contains(typ, "xml") ||
+		strings.Contains(typ, "svg")
+}
+
+func compress(data []byte, encoding string) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if encoding == "br" {
+		w = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	} else {
+		w, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	}
+	w.Write(data)
+	w.Close()
+	// not worth it
+	if buf.Len() >= len(data) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// acceptEncoding return the preferred encoding accepted by request
+func acceptEncoding(r *http.Request, f *asset) string {
+	accepted := map[string]bool{}
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		v = strings.TrimSpace(v)
+		if i := strings.Index(v, ";"); i >= 0 {
+			if strings.Replace(v[i+1:], " ", "", -1) == "q=0" {
+				continue
+			}
+			v = v[:i]
+		}
+		accepted[strings.ToLower(v)] = true
+	}
+
+	switch {
+	case f.br != nil && accepted["br"]:
+		return "br"
+	case f.gz != nil && accepted["gzip"]:
+		return "gzip"
+	}
+	return ""
+}
+
+// ServeHTTP serve file of request path, folders are not listed
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path
+	if strings.HasSuffix(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := a.load(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, etag := f.data, f.etag
+	if f.br != nil || f.gz != nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	switch acceptEncoding(r, f) {
+	case "br":
+		data, etag = f.br, strings.TrimSuffix(etag, `"`)+`-br"`
+		w.Header().Set("Content-Encoding", "br")
+	case "gzip":
+		data, etag = f.gz, strings.TrimSuffix(etag, `"`)+`-gz"`
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+
+	typ := mime.TypeByExtension(path.Ext(name))
+	if typ == "" {
+		typ = http.DetectContentType(f.data)
+	}
+	w.Header().Set("Content-Type", typ)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, name, f.modTime, bytes.NewReader(data))
+}
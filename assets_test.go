@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssetsEmbedded(t *testing.T) {
+	assets, err := NewAssets("")
+	assert.NoError(t, err)
+
+	index, err := assets.ReadFile("index.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(index), "page-data")
+
+	// folders are not listed
+	w := httptest.NewRecorder()
+	assets.ServeHTTP(w, httptest.NewRequest("GET", "/css/", nil))
+	assert.Equal(t, 404, w.Code)
+
+	w = httptest.NewRecorder()
+	assets.ServeHTTP(w, httptest.NewRequest("GET", "/../main.go", nil))
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestAssetsCaching(t *testing.T) {
+	assets, err := NewAssets("")
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	assets.ServeHTTP(w, httptest.NewRequest("GET", "/css/index.css", nil))
+	assert.Equal(t, 200, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+	assert.Equal(t, "text/css; charset=utf-8", w.Header().Get("Content-Type"))
+
+	r := httptest.NewRequest("GET", "/css/index.css", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	assets.ServeHTTP(w, r)
+	assert.Equal(t, 304, w.Code)
+
+	r = httptest.NewRequest("GET", "/css/index.css", nil)
+	r.Header.Set("If-Modified-Since", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	w = httptest.NewRecorder()
+	assets.ServeHTTP(w, r)
+	assert.Equal(t, 304, w.Code)
+}
+
+func TestAssetsCompression(t *testing.T) {
+	assets, err := NewAssets("")
+	assert.NoError(t, err)
+	plain, err := assets.ReadFile("css/bootstrap.css")
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/css/bootstrap.css", nil)
+	r.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	w := httptest.NewRecorder()
+	assets.ServeHTTP(w, r)
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/css; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.True(t, strings.HasSuffix(w.Header().Get("ETag"), `-br"`))
+	data, err := ioutil.ReadAll(brotli.NewReader(w.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, plain, data)
+
+	r.Header.Set("Accept-Encoding", "gzip, br;q=0")
+	w = httptest.NewRecorder()
+	assets.ServeHTTP(w, r)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	zr, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	data, err = ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, data)
+
+	// images are not compressed
+	r = httptest.NewRequest("GET", "/images/send.png", nil)
+	r.Header.Set("Accept-Encoding", "gzip, br")
+	w = httptest.NewRecorder()
+	assets.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+}
+
+func TestAssetsOverride(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "css"), 0755))
+	css := bytes.Repeat([]byte("body { color: red; }\n"), 100)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "css", "index.css"), css, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "css", "index.css.gz"), []byte("precompressed"), 0644))
+
+	assets, err := NewAssets(dir)
+	assert.NoError(t, err)
+
+	// overridden file with precompressed sibling
+	data, err := assets.ReadFile("css/index.css")
+	assert.NoError(t, err)
+	assert.Equal(t, css, data)
+
+	r := httptest.NewRequest("GET", "/css/index.css", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	assets.ServeHTTP(w, r)
+	assert.Equal(t, "precompressed", w.Body.String())
+
+	// others fall back to embedded
+	_, err = assets.ReadFile("index.html")
+	assert.NoError(t, err)
+
+	// changes are picked up
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "css", "index.css"), []byte("p {}"), 0644))
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "css", "index.css"), later, later))
+	data, _ = assets.ReadFile("css/index.css")
+	assert.Equal(t, "p {}", string(data))
+
+	_, err = NewAssets(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
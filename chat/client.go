@@ -1,79 +1,181 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
 	"time"
 
+	"../logging"
 	"../std"
-
-	"github.com/go-clog/clog"
-	"github.com/gorilla/websocket"
 )
 
-const (
+// Options of client connections
+type Options struct {
 	// Time allowed to write a message to the peer.
-	writeWait = 10 * time.Second
+	WriteWait time.Duration `toml:"write_wait" yaml:"write_wait"`
 
 	// Time allowed to read the next pong message from the peer.
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = (pongWait * 9) / 10
+	// Pings are sent to peer every 9/10 of it.
+	PongWait time.Duration `toml:"pong_wait" yaml:"pong_wait"`
 
 	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	MaxMessageSize int64 `toml:"max_message_size" yaml:"max_message_size"`
 
-	// Maximum send queue size
-	maxQueueSize = 1024
-)
+	// Initial send queue size, queues grow when they are full.
+	MaxQueueSize int `toml:"max_queue_size" yaml:"max_queue_size"`
+
+	// Recent messages kept in each room, 0 keeps none.
+	HistorySize int `toml:"history_size" yaml:"history_size"`
+}
+
+// DefaultOptions of client connections
+var DefaultOptions = Options{
+	WriteWait:      10 * time.Second,
+	PongWait:       60 * time.Second,
+	MaxMessageSize: 512,
+	MaxQueueSize:   1024,
+	HistorySize:    100,
+}
 
 var (
 	newline = []byte{'\n'}
 	space   = []byte{' '}
 )
 
-// Client wrap websocket client
+// Client wrap a peer connection, websocket, SSE or long polling
 //
 type Client struct {
 	id  uint64 // client id
 	ids string // client id string
+	lck sync.RWMutex
 	//room string          // room id
-	hub  *RoomHub        // room hub
-	msgs std.Queue       // message queue
-	conn *websocket.Conn // websocket connection
-	quit chan struct{}
-}
-
-// NewClient create an new client instance
-func NewClient(hub *RoomHub, conn *websocket.Conn) *Client {
-	c := &Client{
-		id:   std.GenUniqueID(),
-		hub:  hub,
-		conn: conn,
-		msgs: std.NewSyncQueue(maxQueueSize),
-		quit: make(chan struct{}, 2),
+	hub     *RoomHub     // room hub
+	addr    string       // remote address
+	created time.Time    // connected time
+	opts    Options      // connection options
+	rooms   sync.Map     // ids of joined rooms
+	msgs    std.Queue    // message queue
+	conn    Conn         // transport of peer
+	log     *slog.Logger // logger with client fields
+	metrics *Metrics     // metrics of hub, nil without hub
+	quit    chan struct{}
+}
+
+// NewClient create a client of hub talking over conn,
+// it's served after being added by hub.Connect.
+func NewClient(hub *RoomHub, conn Conn) *Client {
+	c := newClient(hub, conn.RemoteAddr())
+	c.conn = conn
+	return c
+}
+
+func newClient(hub *RoomHub, addr string) *Client {
+	opts := DefaultOptions
+	var metrics *Metrics
+	if hub != nil {
+		opts = hub.Options()
+		metrics = hub.metrics
+	}
+	id := std.GenUniqueID()
+	return &Client{
+		id:      id,
+		hub:     hub,
+		addr:    addr,
+		created: time.Now(),
+		opts:    opts,
+		msgs:    std.NewSyncQueue(opts.MaxQueueSize),
+		log:     clientLog.With("client", id, "addr", addr),
+		metrics: metrics,
+		quit:    make(chan struct{}, 2),
 	}
-	//c.ids = fmt.Sprintf("%d", c.id)
+}
 
-	// handler websocket read/write client in seperate goroutine
+// start handle read/write of client connection in seperate goroutine
+func (c *Client) start() {
 	go c.readPump()
 	go c.writePump()
-	return c
+}
+
+// ID return client id
+func (c *Client) ID() uint64 {
+	return c.id
+}
+
+// User return the user name bound to client by its first message
+func (c *Client) User() string {
+	c.lck.RLock()
+	defer c.lck.RUnlock()
+	return c.ids
+}
+
+// bindUser bind the user name of first message to client, and return it.
+// Later messages are sent on behalf of the bound user, whoever they claim.
+func (c *Client) bindUser(name string) string {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	if c.ids == "" {
+		c.ids = name
+	}
+	return c.ids
+}
+
+// RemoteAddr return client remote address
+func (c *Client) RemoteAddr() string {
+	return c.addr
+}
+
+// Created return the time client connected
+func (c *Client) Created() time.Time {
+	return c.created
+}
+
+// QueueLen return number of messages waiting to be sent
+func (c *Client) QueueLen() int {
+	return c.msgs.Len()
+}
+
+// Rooms return ids of rooms client joined
+func (c *Client) Rooms() []string {
+	res := make([]string, 0)
+	c.rooms.Range(func(key, value interface{}) bool {
+		if id, ok := key.(string); ok {
+			res = append(res, id)
+		}
+		return true
+	})
+	return res
+}
+
+// Close disconnect client
+func (c *Client) Close() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
 }
 
 // PushMessage push message to client
 func (c *Client) PushMessage(msg *Message) bool {
-	return c.msgs.Add(msg)
+	if !c.msgs.Add(msg) {
+		queueLog.Debug("queue closed, message dropped", "client", c.id, "type", msg.Type, "msg_id", msg.ID)
+		c.metrics.messageDropped(dropClosed)
+		return false
+	}
+	if n := c.msgs.Len(); n > c.opts.MaxQueueSize {
+		queueLog.Debug("queue backlog over size", "client", c.id, "len", n, "size", c.opts.MaxQueueSize)
+	}
+	return true
 }
 
 // OnMessage handle client message
 func (c *Client) OnMessage(msg *Message) {
 	switch msg.Type {
 	case T_MESSAGE:
-		clog.Trace("client %v send message: %v.", msg.From, msg.Data)
+		c.log.Log(context.Background(), logging.LevelTrace, "send message", "user", msg.From, "room", msg.Room, "msg_id", msg.ID)
 		if msg.Room == "" {
 			msg.Data = "no room specified"
-			c.ids = msg.From
 			c.PushMessage(msg)
 		} else {
 			c.hub.Broadcast(msg)
@@ -81,93 +183,165 @@ func (c *Client) OnMessage(msg *Message) {
 
 	case T_ROOMS:
 		reply := &Message{Type: T_ROOMS}
-		clog.Trace("client %s get room list", msg.From)
+		c.log.Debug("get room list", "user", msg.From)
 		if res := c.hub.RoomList(); res != nil {
 			if bs, err := json.Marshal(res); err == nil {
 				reply.Data = string(bs)
 				reply.From = msg.From
 			} else {
-				clog.Error(2, "marshal rooms (%v) failed: %v.", res, err)
+				c.log.Error("marshal rooms failed", "err", err)
 			}
 		}
 		c.PushMessage(reply)
 
 	case T_JOIN:
 		reply := &Message{Type: T_JOIN}
-		clog.Trace("client %s join room %s", msg.From, msg.Room)
-		if c.hub.JoinRoom(c, msg.Room) {
-			reply.Data = msg.Room
+		c.log.Debug("join room", "user", msg.From, "room", msg.Room)
+		rm := c.hub.GetRoom(msg.Room)
+		if rm == nil {
+			c.replyError(msg, ErrRoomNotFound)
+			break
+		}
+		if err := c.hub.JoinRoom(c, rm.ID); err != nil {
+			c.replyError(msg, err)
+			break
 		}
+		reply.Room = rm.ID
+		reply.Data = rm.ID
 		c.PushMessage(reply)
 
 	case T_LEAVE:
 		reply := &Message{Type: T_LEAVE}
-		clog.Trace("client %s leave room %s", msg.From, msg.Room)
-		if c.hub.LeaveRoom(c, msg.Room) {
-			reply.Data = msg.Room
+		c.log.Debug("leave room", "user", msg.From, "room", msg.Room)
+		if err := c.hub.LeaveRoom(c, msg.Room); err != nil {
+			c.replyError(msg, err)
+			break
 		}
+		reply.Data = msg.Room
 		c.PushMessage(reply)
 
 	case T_CREATE:
-		reply := &Message{Type: T_CREATE}
-		clog.Trace("client %s create room %s", msg.From, msg.Data)
-		if rm := c.hub.NewRoom(msg.Data); rm != nil {
-			if bs, err := json.Marshal(rm); err == nil {
-				reply.Data = string(bs)
-			} else {
-				clog.Error(2, "marshal room %+v failed: %v.", rm, err)
-			}
+		c.log.Debug("create room", "user", msg.From, "name", msg.Data)
+		rm, err := c.hub.NewRoom(msg.Data, msg.From)
+		if err != nil {
+			c.replyError(msg, err)
+			break
 		}
-		c.PushMessage(reply)
+		c.replyRoom(T_CREATE, rm)
+
+	case T_RENAME:
+		c.log.Debug("rename room", "user", msg.From, "room", msg.Room, "name", msg.Data)
+		if err := c.checkOwner(msg); err != nil {
+			c.replyError(msg, err)
+			break
+		}
+		rm, err := c.hub.RenameRoom(msg.Room, msg.From, msg.Data)
+		if err != nil {
+			c.replyError(msg, err)
+			break
+		}
+		c.replyRoom(T_RENAME, rm)
+
+	case T_UPDATE:
+		c.log.Debug("update room", "user", msg.From, "room", msg.Room, "update", msg.Data)
+		var up RoomUpdate
+		if err := json.Unmarshal([]byte(msg.Data), &up); err != nil {
+			c.replyError(msg, err)
+			break
+		}
+		if err := c.checkOwner(msg); err != nil {
+			c.replyError(msg, err)
+			break
+		}
+		rm, err := c.hub.UpdateRoom(msg.Room, msg.From, &up)
+		if err != nil {
+			c.replyError(msg, err)
+			break
+		}
+		c.replyRoom(T_UPDATE, rm)
 
 	default:
-		clog.Trace("unknown message type %v from client %s.", msg.Type, c.ids)
+		c.log.Debug("unknown message type", "user", c.User(), "type", msg.Type)
+	}
+}
+
+// checkOwner check user of client owns the room message targets. User
+// names aren't verified, the name bound by the first message is trusted,
+// so it only keeps honest users from changing rooms of others.
+func (c *Client) checkOwner(msg *Message) error {
+	rm := c.hub.GetRoom(msg.Room)
+	if rm == nil {
+		return ErrRoomNotFound
 	}
+	if user := c.User(); rm.Owner == "" || rm.Owner != user {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// replyRoom reply room information to client
+func (c *Client) replyRoom(typ string, rm *RoomInfo) {
+	reply := &Message{Type: typ, Room: rm.ID}
+	if bs, err := json.Marshal(rm); err == nil {
+		reply.Data = string(bs)
+	} else {
+		c.log.Error("marshal room failed", "room", rm.ID, "err", err)
+	}
+	c.PushMessage(reply)
+}
+
+// replyError reply request failure to client
+func (c *Client) replyError(msg *Message, err error) {
+	c.PushMessage(&Message{
+		Type:      T_ERROR,
+		Room:      msg.Room,
+		Timestamp: std.GetNowMs(),
+		Data:      err.Error(),
+	})
 }
 
 func (c *Client) readPump() {
 	defer func() {
 		c.conn.Close()
 		close(c.quit)
-		clog.Info("client %d read routine end.", c.id)
+		c.hub.disconnect(c)
+		c.log.Debug("read routine end", "user", c.User())
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
-		return nil
-	})
-
 	for {
-		var msg Message
-		if err := c.conn.ReadJSON(&msg); err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				clog.Error(2, "client %v read message error: %v", c.id, err)
+		msg, err := c.conn.ReadMessage()
+		if err != nil {
+			if err != io.EOF {
+				c.log.Error("read message failed", "user", c.User(), "err", err)
+				c.metrics.websocketError(ErrKindRead)
 			} else {
-				clog.Trace("client %v closed.", c.id)
+				c.log.Debug("client closed", "user", c.User())
 			}
 			return
 		}
 
-		c.ids = msg.From
+		msg.From = c.bindUser(msg.From)
+		// ids are assigned by rooms, so clients can't replace others' messages
+		msg.ID = 0
 		msg.Timestamp = std.GetNowMs()
-		if c.hub.OnMessage(&msg); msg.Discard {
-			clog.Info("discard cleint %s message %v.", c.id, msg)
-			continue
+		msg.Source = c.conn
+		c.metrics.messageReceived(msg.Type)
+		if err := c.hub.Handle(c, msg); err == ErrDiscard {
+			c.metrics.messageDropped(dropDiscarded)
+			c.log.Info("message discarded", "user", msg.From, "room", msg.Room, "type", msg.Type, "msg_id", msg.ID)
+		} else if err != nil {
+			c.replyError(msg, err)
 		}
-
-		c.OnMessage(&msg)
 	}
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.opts.PongWait * 9 / 10)
 	defer func() {
 		ticker.Stop()
 		c.msgs.Close()
 		c.conn.Close()
-		clog.Info("client %d write routine end.", c.id)
+		c.log.Debug("write routine end", "user", c.User())
 	}()
 
 	var (
@@ -180,41 +354,35 @@ func (c *Client) writePump() {
 		select {
 		case v, ok := <-chMsg:
 			if !ok && c.hub.IsClosed() {
-				clog.Warn("websocket server closed.")
+				c.log.Warn("websocket server closed")
 				return
 			}
 			if msg, ok = v.(*Message); !ok {
 				continue
 			}
 
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			err = c.conn.WriteJSON(msg)
-
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-					clog.Error(2, "client %d writer error: %v", c.id, err)
+			if err = c.conn.WriteMessage(msg); err != nil {
+				if err != io.EOF {
+					c.log.Error("write message failed", "user", c.User(), "err", err)
+					c.metrics.websocketError(ErrKindWrite)
 				} else {
-					clog.Trace("client %v closed.", c.id)
+					c.log.Debug("client closed", "user", c.User())
 				}
 				return
 			}
-			if msg.Type == T_CLOSE {
-				return
-			}
 
-			clog.Trace("send message %+v to client %v.", msg.Data, c.id)
+			c.metrics.messageSent(msg.Type)
+			c.log.Log(context.Background(), logging.LevelTrace, "message sent", "user", c.User(), "room", msg.Room, "type", msg.Type, "msg_id", msg.ID)
 			break
 
 		case <-ticker.C:
 			// heartbeat with client
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			err = c.conn.WriteMessage(websocket.PingMessage, nil)
-
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-					clog.Error(2, "client %d heartbeat error: %v", c.id, err)
+			if err = c.conn.Ping(); err != nil {
+				if err != io.EOF {
+					c.log.Error("heartbeat failed", "user", c.User(), "err", err)
+					c.metrics.websocketError(ErrKindPing)
 				} else {
-					clog.Trace("client %v heartbeat closed.", c.id)
+					c.log.Debug("heartbeat closed", "user", c.User())
 				}
 				return
 			}
@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrConnClosed the connection has been closed
+var ErrConnClosed = errors.New("connection closed")
+
+// Conn is the transport of a client, rooms and the hub only see clients,
+// so they don't care whether peers use websocket, SSE or long polling.
+// ReadMessage is called by one goroutine, WriteMessage and Ping by
+// another one.
+type Conn interface {
+	// ReadMessage block until next message from peer,
+	// return io.EOF if peer closed the connection normally.
+	ReadMessage() (*Message, error)
+
+	// WriteMessage send message to peer
+	WriteMessage(msg *Message) error
+
+	// Ping check peer is alive, called every 9/10 of PongWait
+	Ping() error
+
+	// Close the connection, blocked reads and writes return
+	Close() error
+
+	// RemoteAddr return address of peer
+	RemoteAddr() string
+}
+
+// wsConn is the websocket transport
+type wsConn struct {
+	conn    *websocket.Conn
+	opts    Options
+	metrics *Metrics
+}
+
+func newWSConn(conn *websocket.Conn, opts Options, metrics *Metrics) *wsConn {
+	c := &wsConn{conn: conn, opts: opts, metrics: metrics}
+	conn.SetReadLimit(opts.MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(opts.PongWait))
+	conn.SetPongHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(opts.PongWait))
+		// pings carry the time they were sent
+		if sent, err := strconv.ParseInt(data, 10, 64); err == nil {
+			metrics.ping(time.Since(time.Unix(0, sent)))
+		}
+		return nil
+	})
+	return c
+}
+
+// closeError return err if peer closed with an unexpected close code,
+// otherwise io.EOF, network errors mostly mean peer has gone.
+func closeError(err error) error {
+	if err == nil || websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+		return err
+	}
+	return io.EOF
+}
+
+func (c *wsConn) ReadMessage() (*Message, error) {
+	var msg Message
+	if err := c.conn.ReadJSON(&msg); err != nil {
+		return nil, closeError(err)
+	}
+	return &msg, nil
+}
+
+func (c *wsConn) WriteMessage(msg *Message) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteWait))
+	return closeError(c.conn.WriteJSON(msg))
+}
+
+func (c *wsConn) Ping() error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteWait))
+	return closeError(c.conn.WriteMessage(websocket.PingMessage, []byte(strconv.FormatInt(time.Now().UnixNano(), 10))))
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *wsConn) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
+}
@@ -0,0 +1,240 @@
+package chat
+
+import (
+	"../std"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+)
+
+// handler priorities, handlers with smaller priority run first,
+// handlers with the same priority run in the order they were added.
+const (
+	PriorityFirst  = -100
+	PriorityNormal = 0
+	PriorityLast   = 100
+)
+
+// ErrDiscard returned by handler to stop processing a message silently,
+// the client won't get an error reply.
+var ErrDiscard = errors.New("message discarded")
+
+// Context of a message going through the handler chain
+type Context struct {
+	Hub       *RoomHub // room hub
+	Client    *Client  // client sent the message, nil if not sent by client
+	User      string   // user who sent the message
+	Room      string   // id of room the message targets, empty if none
+	RequestID string   // unique id to trace the message
+	Message   *Message // the message, handlers can modify it in place
+}
+
+// HandlerFunc process message in context,
+// return error to reject the message with an error reply.
+type HandlerFunc func(ctx *Context) error
+
+// Middleware wraps the next handler in chain, it may modify the message,
+// reject it by returning an error or stop the chain by not calling next.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// PostHook called after message has been processed and broadcast,
+// err is what the handler chain returned.
+type PostHook func(ctx *Context, err error)
+
+type middleware struct {
+	priority int
+	fn       Middleware
+}
+
+// chain is an ordered list of middlewares
+type chain struct {
+	lck sync.RWMutex
+	mws []middleware
+}
+
+func (c *chain) add(priority int, fn Middleware) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	// insert after all middlewares with priority not greater than the new one
+	idx := sort.Search(len(c.mws), func(i int) bool {
+		return c.mws[i].priority > priority
+	})
+	mws := make([]middleware, 0, len(c.mws)+1)
+	mws = append(mws, c.mws[:idx]...)
+	mws = append(mws, middleware{priority: priority, fn: fn})
+	c.mws = append(mws, c.mws[idx:]...)
+}
+
+func (c *chain) list() []middleware {
+	c.lck.RLock()
+	mws := c.mws
+	c.lck.RUnlock()
+	return mws
+}
+
+// merge middlewares of two chains by priority, hub wide ones go first
+// if they have the same priority.
+func merge(a, b []middleware) []middleware {
+	if len(b) == 0 {
+		return a
+	}
+	res := make([]middleware, 0, len(a)+len(b))
+	res = append(res, a...)
+	res = append(res, b...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].priority < res[j].priority
+	})
+	return res
+}
+
+// Use add hub wide middleware with given priority
+func (h *RoomHub) Use(priority int, mw Middleware) {
+	if mw != nil {
+		h.chain.add(priority, mw)
+	}
+}
+
+// UseRoom add middleware only for messages sent to given room
+func (h *RoomHub) UseRoom(roomID string, priority int, mw Middleware) error {
+	r, ok := h.findRoom(roomID)
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if mw != nil {
+		r.chain.add(priority, mw)
+	}
+	return nil
+}
+
+// AddPostHooks add hooks called after messages have been processed
+func (h *RoomHub) AddPostHooks(hooks ...PostHook) {
+	h.lck.Lock()
+	defer h.lck.Unlock()
+	for _, hook := range hooks {
+		if hook != nil {
+			h.posts = append(h.posts, hook)
+		}
+	}
+}
+
+// Handle run message through the handler chain, the hub wide middlewares
+// and middlewares of the target room are sorted by priority, then the
+// message is processed by client, or broadcast if there is no client.
+func (h *RoomHub) Handle(c *Client, msg *Message) (err error) {
+	ctx := h.newContext(c, msg)
+	next := h.handler(ctx, h.process)
+
+	defer func() {
+		if e := recover(); e != nil {
+			hubLog.Error("panic handling message", "user", msg.From, "room", msg.Room, "type", msg.Type,
+				"msg_id", msg.ID, "request_id", ctx.RequestID, "err", e, "stack", string(debug.Stack()))
+			err = fmt.Errorf("internal error")
+		}
+
+		h.lck.RLock()
+		posts := h.posts
+		h.lck.RUnlock()
+		for _, hook := range posts {
+			hook(ctx, err)
+		}
+	}()
+	return next(ctx)
+}
+
+// Post message from a service into room, it goes through the handler chain
+// like messages of clients, then is broadcast.
+func (h *RoomHub) Post(room, from, data string) (*Message, error) {
+	if data == "" {
+		return nil, ErrEmptyMessage
+	}
+	if max := h.Options().MaxMessageSize; int64(len(data)) > max {
+		return nil, fmt.Errorf("%w, max %d bytes", ErrTooLong, max)
+	}
+	rm := h.GetRoom(room)
+	if rm == nil {
+		return nil, ErrRoomNotFound
+	}
+	msg := &Message{
+		Type:      T_MESSAGE,
+		From:      from,
+		Room:      rm.ID,
+		Timestamp: std.GetNowMs(),
+		Data:      data,
+	}
+	if err := h.Handle(nil, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// OnMessage run message through the handler chain without processing it,
+// Discard of message is set if any handler rejected it.
+//
+// Deprecated: use Handle, which processes the message and returns the error.
+func (h *RoomHub) OnMessage(msg *Message) {
+	defer func() {
+		if e := recover(); e != nil {
+			hubLog.Error("panic handling message", "user", msg.From, "room", msg.Room, "type", msg.Type,
+				"msg_id", msg.ID, "err", e, "stack", string(debug.Stack()))
+			msg.Discard = true
+		}
+	}()
+
+	ctx := h.newContext(nil, msg)
+	if err := h.handler(ctx, func(*Context) error { return nil })(ctx); err != nil {
+		msg.Discard = true
+	}
+}
+
+// newContext create context of message, in the room it's sent to
+func (h *RoomHub) newContext(c *Client, msg *Message) *Context {
+	ctx := &Context{
+		Hub:       h,
+		Client:    c,
+		User:      msg.From,
+		RequestID: std.GenUIDs(),
+		Message:   msg,
+	}
+	if r, ok := h.findRoom(msg.Room); ok {
+		ctx.Room = r.ID
+	}
+	return ctx
+}
+
+// handler chain hub wide and room middlewares sorted by priority, ending with last
+func (h *RoomHub) handler(ctx *Context, last HandlerFunc) HandlerFunc {
+	mws := h.chain.list()
+	if r, ok := h.findRoom(ctx.Room); ok {
+		mws = merge(mws, r.chain.list())
+	}
+
+	next := last
+	for i := len(mws) - 1; i >= 0; i-- {
+		next = mws[i].fn(next)
+	}
+	return next
+}
+
+// process is the last handler in chain
+func (h *RoomHub) process(ctx *Context) error {
+	if ctx.Client != nil {
+		ctx.Client.OnMessage(ctx.Message)
+	} else if ctx.Message.Type == T_MESSAGE {
+		h.Broadcast(ctx.Message)
+	}
+	return nil
+}
+
+// middleware adapt MessageHandler to middleware,
+// message set Discard flag by handler won't be processed.
+func (fn MessageHandler) middleware(next HandlerFunc) HandlerFunc {
+	return func(ctx *Context) error {
+		if fn(ctx.Message, ctx.Hub); ctx.Message.Discard {
+			return ErrDiscard
+		}
+		return next(ctx)
+	}
+}
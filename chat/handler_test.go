@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleChain(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	var trace []string
+	mark := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx *Context) error {
+				trace = append(trace, name)
+				return next(ctx)
+			}
+		}
+	}
+	hub.Use(PriorityLast, mark("last"))
+	hub.Use(PriorityNormal, mark("normal"))
+	hub.Use(PriorityFirst, mark("first"))
+	assert.NoError(t, hub.UseRoom("general", PriorityNormal, mark("room")))
+	assert.Equal(t, ErrRoomNotFound, hub.UseRoom("random", PriorityNormal, mark("random")))
+
+	hub.AddPostHooks(func(ctx *Context, err error) {
+		assert.Equal(t, rm.ID, ctx.Room)
+		assert.NotEmpty(t, ctx.RequestID)
+		trace = append(trace, "post")
+	})
+
+	assert.NoError(t, hub.Handle(nil, &Message{Type: T_ROOMS, Room: "General"}))
+	assert.Equal(t, []string{"first", "normal", "room", "last", "post"}, trace)
+}
+
+func TestHandleReject(t *testing.T) {
+	hub := NewChatHub()
+
+	spam := errors.New("no spam")
+	hub.Use(PriorityNormal, func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) error {
+			if ctx.Message.Data == "spam" {
+				return spam
+			}
+			return next(ctx)
+		}
+	})
+	hub.AddHandlers(func(msg *Message, hub *RoomHub) {
+		msg.Discard = msg.Data == "noise"
+	})
+
+	var posted []error
+	hub.AddPostHooks(func(ctx *Context, err error) {
+		posted = append(posted, err)
+	})
+
+	assert.Equal(t, spam, hub.Handle(nil, &Message{Type: T_MESSAGE, Data: "spam"}))
+	assert.Equal(t, ErrDiscard, hub.Handle(nil, &Message{Type: T_MESSAGE, Data: "noise"}))
+	assert.NoError(t, hub.Handle(nil, &Message{Type: T_MESSAGE, Data: "hello"}))
+	assert.Equal(t, []error{spam, ErrDiscard, nil}, posted)
+}
+
+func TestOnMessageDeprecated(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	hub.AddHandlers(func(msg *Message, hub *RoomHub) {
+		msg.Discard = msg.Data == "noise"
+	})
+
+	msg := &Message{Type: T_MESSAGE, Room: rm.ID, Data: "noise"}
+	hub.OnMessage(msg)
+	assert.True(t, msg.Discard)
+	msg = &Message{Type: T_MESSAGE, Room: rm.ID, Data: "hello"}
+	hub.OnMessage(msg)
+	assert.False(t, msg.Discard)
+	assert.Equal(t, uint64(0), msg.ID, "not broadcast")
+}
+
+func TestPost(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	msg, err := hub.Post("general", "bot", "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, rm.ID, msg.Room)
+	assert.NotZero(t, msg.ID)
+
+	_, err = hub.Post("general", "bot", "")
+	assert.Equal(t, http.StatusBadRequest, StatusCode(err))
+	_, err = hub.Post("general", "bot", strings.Repeat("x", int(hub.Options().MaxMessageSize)+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, StatusCode(err))
+	_, err = hub.Post("nope", "bot", "hello")
+	assert.Equal(t, http.StatusNotFound, StatusCode(err))
+	assert.Equal(t, http.StatusInternalServerError, StatusCode(errors.New("boom")))
+}
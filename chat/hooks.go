@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"net/http"
+)
+
+// ConnectHook called before client connection upgraded, return error to
+// reject the connection. r is nil for connections served by Connect.
+type ConnectHook func(c *Client, r *http.Request) error
+
+// DisconnectHook called after client disconnected, or its connection
+// has been rejected by connect hooks.
+type DisconnectHook func(c *Client)
+
+// JoinHook called before client join room, return error to veto it
+type JoinHook func(c *Client, room *RoomInfo) error
+
+// LeaveHook called after client left room
+type LeaveHook func(c *Client, room *RoomInfo)
+
+// RoomHook called after room created or closed
+type RoomHook func(room *RoomInfo)
+
+// hooks registered to room hub
+type hooks struct {
+	connect    []ConnectHook
+	disconnect []DisconnectHook
+	join       []JoinHook
+	leave      []LeaveHook
+	create     []RoomHook
+	close      []RoomHook
+}
+
+// OnConnect add hook called before client connection upgraded
+func (h *RoomHub) OnConnect(fn ConnectHook) {
+	h.lck.Lock()
+	h.hooks.connect = append(h.hooks.connect, fn)
+	h.lck.Unlock()
+}
+
+// OnDisconnect add hook called after client disconnected
+func (h *RoomHub) OnDisconnect(fn DisconnectHook) {
+	h.lck.Lock()
+	h.hooks.disconnect = append(h.hooks.disconnect, fn)
+	h.lck.Unlock()
+}
+
+// OnJoin add hook called before client join room
+func (h *RoomHub) OnJoin(fn JoinHook) {
+	h.lck.Lock()
+	h.hooks.join = append(h.hooks.join, fn)
+	h.lck.Unlock()
+}
+
+// OnLeave add hook called after client left room
+func (h *RoomHub) OnLeave(fn LeaveHook) {
+	h.lck.Lock()
+	h.hooks.leave = append(h.hooks.leave, fn)
+	h.lck.Unlock()
+}
+
+// OnRoomCreate add hook called after room created
+func (h *RoomHub) OnRoomCreate(fn RoomHook) {
+	h.lck.Lock()
+	h.hooks.create = append(h.hooks.create, fn)
+	h.lck.Unlock()
+}
+
+// OnRoomClose add hook called after room closed
+func (h *RoomHub) OnRoomClose(fn RoomHook) {
+	h.lck.Lock()
+	h.hooks.close = append(h.hooks.close, fn)
+	h.lck.Unlock()
+}
+
+// getHooks return a snapshot of registered hooks
+func (h *RoomHub) getHooks() hooks {
+	h.lck.RLock()
+	hs := h.hooks
+	h.lck.RUnlock()
+	return hs
+}
+
+func (h *RoomHub) onConnect(c *Client, r *http.Request) error {
+	for _, fn := range h.getHooks().connect {
+		if err := fn(c, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *RoomHub) onDisconnect(c *Client) {
+	for _, fn := range h.getHooks().disconnect {
+		fn(c)
+	}
+}
+
+func (h *RoomHub) onJoin(c *Client, room *RoomInfo) error {
+	for _, fn := range h.getHooks().join {
+		if err := fn(c, room); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *RoomHub) onLeave(c *Client, room *RoomInfo) {
+	for _, fn := range h.getHooks().leave {
+		fn(c, room)
+	}
+}
+
+func (h *RoomHub) onRoomCreate(room *RoomInfo) {
+	for _, fn := range h.getHooks().create {
+		fn(room)
+	}
+}
+
+func (h *RoomHub) onRoomClose(room *RoomInfo) {
+	for _, fn := range h.getHooks().close {
+		fn(room)
+	}
+}
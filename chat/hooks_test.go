@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoomHooks(t *testing.T) {
+	hub := NewChatHub()
+
+	var created, closed []string
+	hub.OnRoomCreate(func(room *RoomInfo) {
+		created = append(created, room.Name)
+	})
+	hub.OnRoomClose(func(room *RoomInfo) {
+		closed = append(closed, room.Name)
+	})
+
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+	_, err = hub.NewRoom("general", "")
+	assert.Equal(t, ErrRoomExists, err)
+
+	assert.NotNil(t, hub.DeleteRoom(rm.ID))
+	assert.Nil(t, hub.DeleteRoom(rm.ID))
+	assert.Equal(t, []string{"general"}, created)
+	assert.Equal(t, []string{"general"}, closed)
+}
+
+func TestJoinVeto(t *testing.T) {
+	hub := NewChatHub()
+	_, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	full := errors.New("room is full")
+	hub.OnJoin(func(c *Client, room *RoomInfo) error {
+		if room.Slug == "general" {
+			return full
+		}
+		return nil
+	})
+
+	c := newClient(hub, "127.0.0.1:1234")
+	assert.Equal(t, full, hub.JoinRoom(c, "general"))
+	assert.Equal(t, ErrRoomNotFound, hub.JoinRoom(c, "random"))
+}
+
+func TestLeaveHook(t *testing.T) {
+	hub := NewChatHub()
+	_, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	var left []string
+	hub.OnLeave(func(c *Client, room *RoomInfo) {
+		left = append(left, room.Slug)
+	})
+
+	c := newClient(hub, "127.0.0.1:1234")
+	assert.NoError(t, hub.LeaveRoom(c, "general"))
+	assert.Empty(t, left, "not joined")
+
+	assert.NoError(t, hub.JoinRoom(c, "general"))
+	assert.NoError(t, hub.LeaveRoom(c, "general"))
+	assert.NoError(t, hub.LeaveRoom(c, "general"))
+	assert.Equal(t, []string{"general"}, left)
+}
+
+func TestConnectVeto(t *testing.T) {
+	hub := NewChatHub()
+	// pipes have addresses of their own, limit them by a hook
+	hub.OnConnect(func(c *Client, r *http.Request) error {
+		if len(hub.Clients()) > 0 {
+			return ErrTooManyConns
+		}
+		return nil
+	})
+
+	var disconnected int
+	hub.OnDisconnect(func(c *Client) {
+		disconnected++
+	})
+
+	local, _ := Pipe()
+	_, err := hub.Connect(local)
+	assert.NoError(t, err)
+	local, peer := Pipe()
+	_, err = hub.Connect(local)
+	assert.Equal(t, ErrTooManyConns, err)
+	assert.Equal(t, 1, disconnected)
+	assert.Len(t, hub.Clients(), 1)
+	_, err = peer.ReadMessage()
+	assert.Equal(t, io.EOF, err, "rejected conn is closed")
+}
@@ -0,0 +1,347 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"../std"
+)
+
+const (
+	// longest time a poll request waits for messages
+	pollWait = 25 * time.Second
+
+	// most messages returned by a poll request, and kept until acknowledged
+	pollBatch = 64
+
+	// SessionHeader is the header carrying session id of SSE and long
+	// polling requests
+	SessionHeader = "X-Sparrow-Session"
+)
+
+// httpConn is the transport of SSE and long polling clients, peers receive
+// messages from GET /sse or /poll, and send messages by POST /send, all
+// requests carry the session id given when the session was created in
+// SessionHeader.
+type httpConn struct {
+	hub     *RoomHub
+	session string        // session id
+	addr    string        // remote address
+	poll    bool          // long polling, otherwise SSE
+	wait    time.Duration // longest time a write waits for peer
+	in      chan *Message // messages sent by peer
+	out     chan *Message // messages to peer, nil is a SSE ping
+	lck     sync.Mutex
+	seen    time.Time  // last time peer received messages
+	acked   uint64     // cursor of last message acknowledged by poll
+	unacked []*Message // messages polled but not acknowledged, after acked
+	once    sync.Once
+	closed  chan struct{}
+}
+
+func newHTTPConn(hub *RoomHub, addr string, poll bool) *httpConn {
+	return &httpConn{
+		hub:     hub,
+		session: std.GenUIDs(),
+		addr:    addr,
+		poll:    poll,
+		wait:    hub.Options().PongWait,
+		in:      make(chan *Message),
+		out:     make(chan *Message, pollBatch),
+		seen:    time.Now(),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (c *httpConn) ReadMessage() (*Message, error) {
+	select {
+	case msg := <-c.in:
+		return msg, nil
+	case <-c.closed:
+		return nil, io.EOF
+	}
+}
+
+// WriteMessage wait for peer to take the message, peers not polling
+// within PongWait are gone.
+func (c *httpConn) WriteMessage(msg *Message) error {
+	timer := time.NewTimer(c.wait)
+	defer timer.Stop()
+	select {
+	case c.out <- msg:
+		return nil
+	case <-c.closed:
+		return io.EOF
+	case <-timer.C:
+		return io.EOF
+	}
+}
+
+// Ping fail if peer hasn't received messages within PongWait,
+// SSE streams get a comment to keep proxies from closing them.
+func (c *httpConn) Ping() error {
+	c.lck.Lock()
+	seen := c.seen
+	c.lck.Unlock()
+	if time.Since(seen) > c.wait {
+		return io.EOF
+	}
+	if !c.poll {
+		select {
+		case c.out <- nil:
+		default:
+		}
+	}
+	return nil
+}
+
+func (c *httpConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.hub.sessions.Delete(c.session)
+	})
+	return nil
+}
+
+func (c *httpConn) RemoteAddr() string {
+	return c.addr
+}
+
+// touch mark peer alive
+func (c *httpConn) touch() {
+	c.lck.Lock()
+	c.seen = time.Now()
+	c.lck.Unlock()
+}
+
+// ack drop polled messages up to cursor, and return the others
+func (c *httpConn) ack(cursor uint64) []*Message {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	if cursor > c.acked {
+		n := cursor - c.acked
+		if n > uint64(len(c.unacked)) {
+			n = uint64(len(c.unacked))
+		}
+		c.unacked = c.unacked[n:]
+		c.acked += n
+	}
+	return c.unacked
+}
+
+// take messages queued for peer, they are kept until acknowledged,
+// returned with the cursor of the last one.
+func (c *httpConn) take(msgs ...*Message) ([]*Message, uint64) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	for _, msg := range msgs {
+		if msg != nil {
+			c.unacked = append(c.unacked, msg)
+		}
+	}
+	for len(c.unacked) < pollBatch {
+		select {
+		case msg := <-c.out:
+			if msg != nil {
+				c.unacked = append(c.unacked, msg)
+			}
+			continue
+		default:
+		}
+		break
+	}
+	return append([]*Message(nil), c.unacked...), c.acked + uint64(len(c.unacked))
+}
+
+// connectHTTP create client of a new SSE or long polling session,
+// nil is returned if it's rejected, with error written to response.
+func (h *RoomHub) connectHTTP(w http.ResponseWriter, r *http.Request, poll bool) *httpConn {
+	c := newClient(h, r.RemoteAddr)
+	if !h.checkOrigin(r) {
+		h.metrics.websocketError(ErrKindRejected)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil
+	}
+	if err := h.onConnect(c, r); err != nil {
+		c.log.Warn("http client rejected", "err", err)
+		h.metrics.websocketError(ErrKindRejected)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		h.onDisconnect(c)
+		return nil
+	}
+
+	conn := newHTTPConn(h, r.RemoteAddr, poll)
+	c.conn = conn
+	h.sessions.Store(conn.session, conn)
+	h.serve(c)
+
+	c.log.Debug("http client connected", "poll", poll)
+	return conn
+}
+
+// getSession return connection of session in request
+func (h *RoomHub) getSession(r *http.Request) *httpConn {
+	if v, ok := h.sessions.Load(r.Header.Get(SessionHeader)); ok {
+		return v.(*httpConn)
+	}
+	return nil
+}
+
+// ServeSSE stream messages to an EventSource, the first event is
+// "session" with the session id to send messages by ServeSend.
+func (h *RoomHub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	conn := h.connectHTTP(w, r, false)
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx buffers responses by default
+	w.Header().Set("X-Accel-Buffering", "no")
+	write := func(format string, args ...interface{}) bool {
+		rc.SetWriteDeadline(time.Now().Add(h.Options().WriteWait))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		if err := rc.Flush(); err != nil {
+			return false
+		}
+		conn.touch()
+		return true
+	}
+
+	if !write("event: session\ndata: %s\n\n", conn.session) {
+		return
+	}
+	for {
+		select {
+		case msg := <-conn.out:
+			if msg == nil {
+				if !write(": ping\n\n") {
+					return
+				}
+				break
+			}
+			data, err := json.Marshal(msg)
+			if err != nil || !write("data: %s\n\n", data) {
+				return
+			}
+		case <-conn.closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// ServePoll serve long polling clients. Requests without session create
+// one and return {"session": id}, requests with session in SessionHeader
+// wait for messages and return {"cursor": n, "messages": [...]}, messages
+// empty if none came in time. Messages are returned again by later polls
+// until they are acknowledged by ?cursor= of the last one received, so
+// messages of failed responses are not lost.
+func (h *RoomHub) ServePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(SessionHeader) == "" {
+		if conn := h.connectHTTP(w, r, true); conn != nil {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewEncoder(w).Encode(map[string]string{"session": conn.session})
+		}
+		return
+	}
+
+	conn := h.getSession(r)
+	if conn == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	var cursor uint64
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+	conn.touch()
+	defer conn.touch()
+
+	wait := pollWait
+	if half := h.Options().PongWait / 2; half < wait {
+		wait = half
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	// wait only if nothing is left to redeliver
+	var first *Message
+	if len(conn.ack(cursor)) == 0 {
+		select {
+		case first = <-conn.out:
+		case <-timer.C:
+		case <-conn.closed:
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+	msgs, last := conn.take(first)
+	if msgs == nil {
+		msgs = make([]*Message, 0)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(pollResponse{Cursor: last, Messages: msgs})
+}
+
+// pollResponse is what long polling requests of a session return
+type pollResponse struct {
+	Cursor   uint64     `json:"cursor"`
+	Messages []*Message `json:"messages"`
+}
+
+// ServeSend accept a message posted by SSE or long polling client,
+// the body is a json message and the session is given in SessionHeader.
+func (h *RoomHub) ServeSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// forms of other sites may post too
+	if !h.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	conn := h.getSession(r)
+	if conn == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	var msg Message
+	body := http.MaxBytesReader(w, r.Body, h.Options().MaxMessageSize)
+	if err := json.NewDecoder(body).Decode(&msg); err != nil {
+		http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	select {
+	case conn.in <- &msg:
+		w.WriteHeader(http.StatusAccepted)
+	case <-conn.closed:
+		http.Error(w, "session closed", http.StatusGone)
+	case <-r.Context().Done():
+	}
+}
@@ -0,0 +1,180 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHTTPServer(hub *RoomHub) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", hub.ServeSSE)
+	mux.HandleFunc("/poll", hub.ServePoll)
+	mux.HandleFunc("/send", hub.ServeSend)
+	return httptest.NewServer(mux)
+}
+
+func send(t *testing.T, url, session string, msg *Message) int {
+	body, _ := json.Marshal(msg)
+	req, _ := http.NewRequest(http.MethodPost, url+"/send", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SessionHeader, session)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServeSSE(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+	srv := newHTTPServer(hub)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/sse")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() (event, data string) {
+		for events.Scan() {
+			line := events.Text()
+			switch {
+			case line == "" && data != "":
+				return
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		return
+	}
+	event, session := next()
+	assert.Equal(t, "session", event)
+	assert.NotEmpty(t, session)
+
+	assert.Equal(t, http.StatusAccepted, send(t, srv.URL, session, &Message{Type: T_JOIN, From: "alice", Room: "general"}))
+	assert.Equal(t, http.StatusAccepted, send(t, srv.URL, session, &Message{Type: T_MESSAGE, From: "alice", Room: rm.ID, Data: "hi"}))
+	var msg Message
+	for msg.Type != T_MESSAGE {
+		_, data := next()
+		assert.NoError(t, json.Unmarshal([]byte(data), &msg))
+	}
+	assert.Equal(t, "hi", msg.Data)
+	assert.Len(t, hub.Clients(), 1)
+
+	assert.Equal(t, http.StatusNotFound, send(t, srv.URL, "random", &Message{Type: T_ROOMS}))
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/send", nil)
+	req.Header.Set(SessionHeader, session)
+	r, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		r.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, r.StatusCode)
+	}
+
+	// closing the stream disconnects client
+	resp.Body.Close()
+	assert.Eventually(t, func() bool {
+		return len(hub.Clients()) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusNotFound, send(t, srv.URL, session, &Message{Type: T_ROOMS}))
+}
+
+func TestServePoll(t *testing.T) {
+	hub := NewChatHub()
+	opts := DefaultOptions
+	opts.PongWait = 200 * time.Millisecond
+	hub.SetOptions(opts)
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+	srv := newHTTPServer(hub)
+	defer srv.Close()
+
+	var cursor uint64
+	poll := func(session string) (int, []*Message) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/poll?cursor="+strconv.FormatUint(cursor, 10), nil)
+		req.Header.Set(SessionHeader, session)
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0, nil
+		}
+		defer resp.Body.Close()
+		var polled pollResponse
+		if resp.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&polled))
+			cursor = polled.Cursor
+		}
+		return resp.StatusCode, polled.Messages
+	}
+
+	resp, err := http.Get(srv.URL + "/poll")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var created struct {
+		Session string `json:"session"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	session := created.Session
+	assert.NotEmpty(t, session)
+
+	// nothing came, empty after waiting
+	start := time.Now()
+	code, msgs := poll(session)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, msgs)
+	assert.True(t, time.Since(start) >= opts.PongWait/2)
+
+	assert.Equal(t, http.StatusAccepted, send(t, srv.URL, session, &Message{Type: T_JOIN, From: "bob", Room: "general"}))
+	assert.Equal(t, http.StatusAccepted, send(t, srv.URL, session, &Message{Type: T_MESSAGE, From: "bob", Room: rm.ID, Data: "hey"}))
+	var got []*Message
+	assert.Eventually(t, func() bool {
+		_, msgs := poll(session)
+		got = append(got, msgs...)
+		return len(got) > 0 && got[len(got)-1].Type == T_MESSAGE
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "hey", got[len(got)-1].Data)
+
+	// response lost, messages are polled again until acknowledged
+	assert.Equal(t, http.StatusAccepted, send(t, srv.URL, session, &Message{Type: T_MESSAGE, From: "bob", Room: rm.ID, Data: "again"}))
+	lost := cursor
+	assert.Eventually(t, func() bool {
+		_, msgs := poll(session)
+		return len(msgs) > 0
+	}, time.Second, time.Millisecond)
+	cursor = lost
+	code, msgs = poll(session)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "again", msgs[0].Data)
+	}
+	code, msgs = poll(session)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, msgs)
+
+	code, _ = poll("random")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// clients stopped polling are gone
+	assert.Eventually(t, func() bool {
+		return len(hub.Clients()) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	code, _ = poll(session)
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -1,96 +1,346 @@
 package chat
 
 import (
+	"../std"
+	"errors"
+	"fmt"
 	"net/http"
-	"net/url"
 	"sync"
 
-	"github.com/go-clog/clog"
 	"github.com/gorilla/websocket"
 )
 
+var (
+	// ErrRoomExists the room name or one of its aliases has been taken
+	ErrRoomExists = errors.New("room already exists")
+
+	// ErrRoomNotFound no room matches the given id, name or alias
+	ErrRoomNotFound = errors.New("room not found")
+
+	// ErrInvalidName room name contains no letter or digit
+	ErrInvalidName = errors.New("invalid room name")
+
+	// ErrNotOwner only room owner can change the room
+	ErrNotOwner = errors.New("not room owner")
+
+	// ErrMessageNotFound message isn't in room history
+	ErrMessageNotFound = errors.New("message not found")
+
+	// ErrHubClosed room hub has been shut down
+	ErrHubClosed = errors.New("hub closed")
+
+	// ErrEmptyMessage message posted without data
+	ErrEmptyMessage = errors.New("message data is required")
+
+	// ErrTooLong message data is longer than max message size
+	ErrTooLong = errors.New("message too long")
+)
+
+// StatusCode return HTTP status code matching error of hub, servers of
+// other protocols translate it into their own codes.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrRoomNotFound), errors.Is(err, ErrMessageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrRoomExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidName), errors.Is(err, ErrEmptyMessage):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrTooLong):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrNotOwner), errors.Is(err, ErrDiscard), errors.Is(err, ErrMuted):
+		return http.StatusForbidden
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrTooManyConns):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrHubClosed):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
 // RoomHub chat room controller
 type RoomHub struct {
-	rooms    sync.Map         // room list
-	clients  sync.Map         // clients not join any room yet
-	handlers []MessageHandler // onmessage handler
+	rooms    sync.Map   // room list
+	slugs    sync.Map   // room slug or alias -> room id
+	clients  sync.Map   // all connected clients
+	sessions sync.Map   // SSE and long polling sessions
+	chain    chain      // hub wide message handlers
+	posts    []PostHook // hooks after message processed
+	hooks    hooks      // client and room lifecycle hooks
+	store    Store      // room persistence, optional
+	origins  OriginPolicy
+	opts     Options
+	upgrade  websocket.Upgrader
+	metrics  *Metrics
+	lck      sync.RWMutex
 	quit     chan struct{}
 }
 
 // NewChatHub create an new chat room
 func NewChatHub() *RoomHub {
-	return &RoomHub{
-		quit:     make(chan struct{}, 1),
-		handlers: make([]MessageHandler, 0),
+	h := &RoomHub{
+		opts: DefaultOptions,
+		quit: make(chan struct{}, 1),
 	}
+	h.upgrade = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     h.checkOrigin,
+	}
+	h.metrics = newMetrics(h)
+	return h
+}
+
+// Metrics return prometheus collector of this hub,
+// register it to expose metrics of clients, rooms and messages.
+func (h *RoomHub) Metrics() *Metrics {
+	return h.metrics
+}
+
+// SetOptions set options of client connections,
+// clients connected before keep their options.
+func (h *RoomHub) SetOptions(opts Options) {
+	h.lck.Lock()
+	h.opts = opts
+	h.lck.Unlock()
+}
+
+// Options return options of client connections
+func (h *RoomHub) Options() Options {
+	h.lck.RLock()
+	defer h.lck.RUnlock()
+	return h.opts
+}
+
+// SetOriginPolicy set origins allowed to open websocket connections
+func (h *RoomHub) SetOriginPolicy(p OriginPolicy) {
+	h.lck.Lock()
+	h.origins = p
+	h.lck.Unlock()
+}
+
+// Upgrader return websocket upgrader of this hub, modify it before serving.
+// Origins are checked by the origin policy, unless CheckOrigin is replaced.
+func (h *RoomHub) Upgrader() *websocket.Upgrader {
+	return &h.upgrade
+}
+
+func (h *RoomHub) checkOrigin(r *http.Request) bool {
+	h.lck.RLock()
+	p := h.origins
+	h.lck.RUnlock()
+	return p.Check(r)
 }
 
 func (h *RoomHub) run() {
 }
 
-// NewRoom create room or return exist one
-func (h *RoomHub) NewRoom(name string) *RoomInfo {
-	if v, ok := h.rooms.Load(name); ok {
-		r, _ := v.(*room)
-		return &r.RoomInfo
+// NewRoom create an new room owned by given user, the room name must be unique.
+// Rooms created by server have no owner.
+func (h *RoomHub) NewRoom(name, owner string) (*RoomInfo, error) {
+	slug := Slugify(name)
+	if slug == "" {
+		return nil, ErrInvalidName
 	}
 
-	r := newRoom(name, h)
+	r := newRoom(RoomInfo{
+		ID:    std.GenUIDs(),
+		Name:  name,
+		Slug:  slug,
+		Owner: owner,
+	}, h)
+	if _, loaded := h.slugs.LoadOrStore(slug, r.ID); loaded {
+		return nil, ErrRoomExists
+	}
 	h.rooms.Store(r.ID, r)
-	return &r.RoomInfo
+	go r.run()
+
+	h.saveRoom(r)
+	info := r.info()
+	h.onRoomCreate(info)
+	return info, nil
 }
 
-// LoadRooms load rooms from database
-func (h *RoomHub) LoadRooms() {
-	// Query From DB
+// RenameRoom change room name on behalf of user, the old name is kept
+// as an alias so clients still using it will be redirected to this room.
+func (h *RoomHub) RenameRoom(roomID, user, name string) (*RoomInfo, error) {
+	r, ok := h.findRoom(roomID)
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	slug := Slugify(name)
+	if slug == "" {
+		return nil, ErrInvalidName
+	}
+	if v, loaded := h.slugs.LoadOrStore(slug, r.ID); loaded && v != r.ID {
+		return nil, ErrRoomExists
+	}
+
+	r.lck.Lock()
+	aliases := make([]string, 0, len(r.Aliases)+1)
+	for _, alias := range append(r.Aliases, r.Slug) {
+		if alias != slug {
+			aliases = append(aliases, alias)
+		}
+	}
+	r.Name, r.Slug, r.Aliases = name, slug, aliases
+	r.lck.Unlock()
+
+	h.saveRoom(r)
+	r.notify(r.event(E_RENAME, user, name))
+	return r.info(), nil
 }
 
-// GetRoom return given room information
-func (h *RoomHub) GetRoom(roomID string) *RoomInfo {
-	if v, ok := h.rooms.Load(roomID); ok {
-		r, _ := v.(*room)
-		return &r.RoomInfo
+// UpdateRoom change room metadata on behalf of user,
+// and notify room members what have been changed.
+func (h *RoomHub) UpdateRoom(roomID, user string, up *RoomUpdate) (*RoomInfo, error) {
+	r, ok := h.findRoom(roomID)
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	events, changed, err := r.update(user, up)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		h.saveRoom(r)
+		for _, msg := range events {
+			r.notify(msg)
+		}
+	}
+	return r.info(), nil
+}
+
+// findRoom lookup room by id, slug or alias
+func (h *RoomHub) findRoom(key string) (*room, bool) {
+	if v, ok := h.rooms.Load(key); ok {
+		r, ok := v.(*room)
+		return r, ok
+	}
+	if id, ok := h.slugs.Load(Slugify(key)); ok {
+		if v, ok := h.rooms.Load(id); ok {
+			r, ok := v.(*room)
+			return r, ok
+		}
+	}
+	return nil, false
+}
+
+// SetStore set room persistence, call it before LoadRooms
+func (h *RoomHub) SetStore(store Store) {
+	h.store = store
+}
+
+// LoadRooms load rooms from store
+func (h *RoomHub) LoadRooms() error {
+	if h.store == nil {
+		return nil
+	}
+
+	rooms, err := h.store.LoadRooms()
+	if err != nil {
+		return err
+	}
+	for _, info := range rooms {
+		r := newRoom(*info, h)
+		if _, loaded := h.slugs.LoadOrStore(r.Slug, r.ID); loaded {
+			hubLog.Warn("room name conflicts, skipped", "room", r.ID, "name", r.Name)
+			continue
+		}
+		for _, alias := range r.Aliases {
+			h.slugs.LoadOrStore(alias, r.ID)
+		}
+		h.rooms.Store(r.ID, r)
+		go r.run()
 	}
 	return nil
 }
 
-// DeleteRoom delete room from room hub
-func (h *RoomHub) DeleteRoom(roomID string) *RoomInfo {
-	if v, ok := h.rooms.Load(roomID); ok {
-		h.rooms.Delete(roomID)
-		r, _ := v.(*room)
-		return &r.RoomInfo
+// saveRoom persist room information, if store is set
+func (h *RoomHub) saveRoom(r *room) {
+	if h.store == nil {
+		return
+	}
+	if err := h.store.SaveRoom(r.info()); err != nil {
+		hubLog.Error("save room failed", "room", r.ID, "err", err)
+	}
+}
+
+// GetRoom return given room information, roomID can be room id, name or alias
+func (h *RoomHub) GetRoom(roomID string) *RoomInfo {
+	if r, ok := h.findRoom(roomID); ok {
+		return r.info()
 	}
 	return nil
 }
 
-// JoinRoom client join room
-func (h *RoomHub) JoinRoom(c *Client, roomID string) bool {
-	if v, ok := h.rooms.Load(roomID); ok {
-		r, _ := v.(*room)
-		select {
-		case r.online <- c:
-			h.RemoveClient(c)
-			return true
-		case <-h.quit:
-			return false
+// DeleteRoom close room and delete it with its aliases from room hub
+func (h *RoomHub) DeleteRoom(roomID string) *RoomInfo {
+	r, ok := h.findRoom(roomID)
+	if !ok {
+		return nil
+	}
+	if _, loaded := h.rooms.LoadAndDelete(r.ID); !loaded {
+		// deleted by others already
+		return nil
+	}
+
+	h.slugs.Range(func(key, value interface{}) bool {
+		if value == r.ID {
+			h.slugs.Delete(key)
+		}
+		return true
+	})
+	if h.store != nil {
+		if err := h.store.DeleteRoom(r.ID); err != nil {
+			hubLog.Error("delete room from store failed", "room", r.ID, "err", err)
 		}
 	}
-	return false
+
+	r.Close()
+	info := r.info()
+	h.onRoomClose(info)
+	return info
+}
+
+// JoinRoom client join room, join hooks may veto it
+func (h *RoomHub) JoinRoom(c *Client, roomID string) error {
+	r, ok := h.findRoom(roomID)
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if err := h.onJoin(c, r.info()); err != nil {
+		return err
+	}
+
+	select {
+	case r.online <- c:
+		c.rooms.Store(r.ID, struct{}{})
+		return nil
+	case <-h.quit:
+		return ErrHubClosed
+	}
 }
 
 // LeaveRoom leave rooom
-func (h *RoomHub) LeaveRoom(c *Client, roomID string) bool {
-	if v, ok := h.rooms.Load(roomID); ok {
-		r, _ := v.(*room)
-		select {
-		case r.offline <- c:
-			return true
-		case <-h.quit:
-			return false
+func (h *RoomHub) LeaveRoom(c *Client, roomID string) error {
+	r, ok := h.findRoom(roomID)
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	select {
+	case r.offline <- c:
+		// hooks only see rooms the client has joined
+		if _, joined := c.rooms.LoadAndDelete(r.ID); joined {
+			h.onLeave(c, r.info())
 		}
+		return nil
+	case <-h.quit:
+		return ErrHubClosed
 	}
-	return false
 }
 
 // IsClosed check if room closed
@@ -103,90 +353,181 @@ func (h *RoomHub) IsClosed() bool {
 	}
 }
 
+// Close shut down the hub, rooms stop and clients are disconnected
+func (h *RoomHub) Close() {
+	h.lck.Lock()
+	if h.IsClosed() {
+		h.lck.Unlock()
+		return
+	}
+	close(h.quit)
+	h.lck.Unlock()
+
+	for _, c := range h.Clients() {
+		c.Close()
+	}
+}
+
+// Ready return nil if the hub is serving and its store is reachable
+func (h *RoomHub) Ready() error {
+	if h.IsClosed() {
+		return ErrHubClosed
+	}
+	if p, ok := h.store.(Pinger); ok {
+		if err := p.Ping(); err != nil {
+			return fmt.Errorf("store: %v", err)
+		}
+	}
+	return nil
+}
+
+// Clients return all connected clients
+func (h *RoomHub) Clients() []*Client {
+	res := make([]*Client, 0)
+	h.clients.Range(func(key, value interface{}) bool {
+		if c, ok := value.(*Client); ok {
+			res = append(res, c)
+		}
+		return true
+	})
+	return res
+}
+
+// GetClient return connected client by id, nil if not found
+func (h *RoomHub) GetClient(id uint64) *Client {
+	if v, ok := h.clients.Load(id); ok {
+		c, _ := v.(*Client)
+		return c
+	}
+	return nil
+}
+
+// RoomClients return clients joined given room, roomID can be room id,
+// name or alias.
+func (h *RoomHub) RoomClients(roomID string) ([]*Client, error) {
+	r, ok := h.findRoom(roomID)
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	res := make([]*Client, 0)
+	for _, c := range h.Clients() {
+		if _, ok := c.rooms.Load(r.ID); ok {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
 // RoomList get room list
 func (h *RoomHub) RoomList() []*RoomInfo {
 	res := make([]*RoomInfo, 0)
 	h.rooms.Range(func(key, value interface{}) bool {
 		if r, ok := value.(*room); ok {
-			res = append(res, &r.RoomInfo)
+			res = append(res, r.info())
 		}
 		return true
 	})
 	return res
 }
 
+// History return at most limit recent messages of room sent before message
+// id before, oldest first. before 0 returns the latest messages.
+func (h *RoomHub) History(roomID string, before uint64, limit int) ([]*Message, error) {
+	r, ok := h.findRoom(roomID)
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	return r.recent(before, limit), nil
+}
+
 // Broadcast message to all online clients
 func (h *RoomHub) Broadcast(msg *Message) {
-	if v, ok := h.rooms.Load(msg.Room); ok {
-		if room, ok := v.(*room); ok {
-			room.Broadcast(msg)
-		}
+	if r, ok := h.findRoom(msg.Room); ok {
+		msg.Room = r.ID
+		r.Broadcast(msg)
 	}
 }
 
-// AddHandlers add on message handler
+// AddHandlers add on message handlers with normal priority
 func (h *RoomHub) AddHandlers(handlers ...MessageHandler) {
-	if len(handlers) == 0 {
-		return
-	}
 	for _, handler := range handlers {
 		if handler != nil {
-			h.handlers = append(h.handlers, handler)
-		}
-	}
-}
-
-// OnMessage handler list
-func (h *RoomHub) OnMessage(msg *Message) {
-	defer func() {
-		if err := recover(); err != nil {
-			clog.Error(2, "Panic to handle message (%v), error %v", msg, err)
-		}
-	}()
-	for _, handler := range h.handlers {
-		if handler(msg, h); msg.Discard {
-			break
+			h.Use(PriorityNormal, handler.middleware)
 		}
 	}
 }
 
-// RemoveClient remove client from unjoin room list
+// RemoveClient remove client from connected client list
 func (h *RoomHub) RemoveClient(c *Client) {
 	if _, ok := h.clients.Load(c.id); ok {
 		h.clients.Delete(c.id)
 	}
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header["Origin"]
-		if len(origin) == 0 {
-			return true
-		}
-		u, err := url.Parse(origin[0])
-		if err != nil {
-			return false
-		}
-		if u.Host != r.Host {
-			clog.Warn("websocket origin not equal: %s - %s", u.Host, r.Host)
+// disconnect leave all rooms the client joined, and remove it from hub
+func (h *RoomHub) disconnect(c *Client) {
+	c.rooms.Range(func(key, value interface{}) bool {
+		if roomID, ok := key.(string); ok {
+			h.LeaveRoom(c, roomID)
 		}
 		return true
-	},
+	})
+	h.RemoveClient(c)
+	h.onDisconnect(c)
 }
 
 // ServeWebsocket websocket connect handler
 func (h *RoomHub) ServeWebsocket(w http.ResponseWriter, r *http.Request) {
 	//serveChatHandler(h, w, r)
-	conn, err := upgrader.Upgrade(w, r, nil)
+	c := newClient(h, r.RemoteAddr)
+	if err := h.onConnect(c, r); err != nil {
+		c.log.Warn("websocket client rejected", "err", err)
+		h.metrics.websocketError(ErrKindRejected)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		h.onDisconnect(c)
+		return
+	}
+
+	conn, err := h.upgrade.Upgrade(w, r, nil)
 	if err != nil {
-		clog.Error(2, "websocket chat connection error: %v", err)
+		c.log.Error("websocket upgrade failed", "err", err)
+		h.metrics.websocketError(ErrKindUpgrade)
+		h.onDisconnect(c)
 		return
 	}
 
+	c.conn = newWSConn(conn, c.opts, h.metrics)
+	h.serve(c)
+
+	c.log.Debug("websocket client connected")
+}
+
+// WebsocketConn wrap websocket connection upgraded by others,
+// so it can be served by Connect.
+func (h *RoomHub) WebsocketConn(conn *websocket.Conn) Conn {
+	return newWSConn(conn, h.Options(), h.metrics)
+}
+
+// Connect serve conn as a client of hub, in-process peers connect with
+// Pipe or Loopback. Connect hooks are called with a nil request, conn is
+// closed if they reject it.
+func (h *RoomHub) Connect(conn Conn) (*Client, error) {
 	c := NewClient(h, conn)
-	h.clients.Store(c.id, c)
+	if err := h.onConnect(c, nil); err != nil {
+		c.log.Warn("client rejected", "err", err)
+		h.metrics.websocketError(ErrKindRejected)
+		conn.Close()
+		h.onDisconnect(c)
+		return nil, err
+	}
+	h.serve(c)
+
+	c.log.Debug("client connected")
+	return c, nil
+}
 
-	clog.Trace("new websocket client %v", c.id)
+// serve add client to hub, and start its read/write routines
+func (h *RoomHub) serve(c *Client) {
+	h.clients.Store(c.id, c)
+	c.start()
 }
@@ -0,0 +1,247 @@
+package chat
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlugify(t *testing.T) {
+	assert.Equal(t, "general", Slugify("General"))
+	assert.Equal(t, "go-nuts", Slugify("  Go   Nuts! "))
+	assert.Equal(t, "默认聊天组", Slugify("默认聊天组"))
+	assert.Equal(t, "", Slugify("#!?"))
+}
+
+func TestNewRoomUnique(t *testing.T) {
+	hub := NewChatHub()
+
+	rm, err := hub.NewRoom("General", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "general", rm.Slug)
+
+	_, err = hub.NewRoom("general", "")
+	assert.Equal(t, ErrRoomExists, err)
+
+	_, err = hub.NewRoom("???", "")
+	assert.Equal(t, ErrInvalidName, err)
+
+	assert.Equal(t, rm, hub.GetRoom(rm.ID))
+	assert.Equal(t, rm, hub.GetRoom("GENERAL"))
+	assert.Nil(t, hub.GetRoom("random"))
+}
+
+func TestRenameRoomAlias(t *testing.T) {
+	hub := NewChatHub()
+
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+	other, err := hub.NewRoom("random", "")
+	assert.NoError(t, err)
+
+	_, err = hub.RenameRoom(rm.ID, "", "Random")
+	assert.Equal(t, ErrRoomExists, err)
+
+	renamed, err := hub.RenameRoom("general", "", "lobby")
+	assert.NoError(t, err)
+	assert.Equal(t, "lobby", renamed.Name)
+	assert.Equal(t, "general", rm.Name, "snapshot is not changed")
+	assert.Equal(t, renamed, hub.GetRoom("lobby"))
+	assert.Equal(t, renamed, hub.GetRoom("general"))
+
+	// renaming back to an alias of itself is allowed
+	_, err = hub.RenameRoom("lobby", "", "general")
+	assert.NoError(t, err)
+
+	assert.Equal(t, rm.ID, hub.DeleteRoom("lobby").ID)
+	assert.Nil(t, hub.GetRoom("general"))
+	assert.Equal(t, other, hub.GetRoom("random"))
+
+	_, err = hub.NewRoom("lobby", "")
+	assert.NoError(t, err)
+}
+
+func TestUpdateRoomPersist(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "rooms.json"))
+	assert.NoError(t, err)
+
+	hub := NewChatHub()
+	hub.SetStore(store)
+	rm, err := hub.NewRoom("general", "alice")
+	assert.NoError(t, err)
+
+	r, _ := hub.findRoom(rm.ID)
+	r.remember(&Message{ID: 1, Type: T_MESSAGE})
+	r.remember(&Message{ID: 2, Type: T_MESSAGE})
+
+	topic := "weekly sync"
+	_, err = hub.UpdateRoom(rm.ID, "alice", &RoomUpdate{Topic: &topic, Pin: []uint64{1, 2}})
+	assert.NoError(t, err)
+	_, err = hub.UpdateRoom(rm.ID, "alice", &RoomUpdate{Unpin: []uint64{1}})
+	assert.NoError(t, err)
+	_, err = hub.RenameRoom(rm.ID, "alice", "lobby")
+	assert.NoError(t, err)
+
+	hub = NewChatHub()
+	hub.SetStore(store)
+	assert.NoError(t, hub.LoadRooms())
+
+	loaded := hub.GetRoom("general")
+	if assert.NotNil(t, loaded) {
+		assert.Equal(t, rm.ID, loaded.ID)
+		assert.Equal(t, "lobby", loaded.Name)
+		assert.Equal(t, "alice", loaded.Owner)
+		assert.Equal(t, topic, loaded.Topic)
+		assert.Equal(t, []uint64{2}, loaded.Pinned)
+	}
+}
+
+func TestUpdateRoomPinSwap(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "alice")
+	assert.NoError(t, err)
+	r, _ := hub.findRoom(rm.ID)
+	r.remember(&Message{ID: 1, Type: T_MESSAGE})
+	r.remember(&Message{ID: 2, Type: T_MESSAGE})
+
+	_, err = hub.UpdateRoom(rm.ID, "alice", &RoomUpdate{Pin: []uint64{1}})
+	assert.NoError(t, err)
+	updated, err := hub.UpdateRoom(rm.ID, "alice", &RoomUpdate{Unpin: []uint64{1}, Pin: []uint64{2}})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{2}, updated.Pinned)
+	assert.Equal(t, []uint64{2}, hub.GetRoom(rm.ID).Pinned)
+}
+
+func TestUpdateRoomPinHistory(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "alice")
+	assert.NoError(t, err)
+
+	// messages not in history can't be pinned, nothing else changes
+	topic := "pins"
+	_, err = hub.UpdateRoom(rm.ID, "alice", &RoomUpdate{Topic: &topic, Pin: []uint64{42}})
+	assert.Equal(t, ErrMessageNotFound, err)
+	assert.Empty(t, hub.GetRoom(rm.ID).Topic)
+
+	msg, err := hub.Post(rm.ID, "bob", "pin me")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, err = hub.UpdateRoom(rm.ID, "alice", &RoomUpdate{Pin: []uint64{msg.ID}})
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []uint64{msg.ID}, hub.GetRoom(rm.ID).Pinned)
+}
+
+func TestHistoryOrder(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				hub.Post(rm.ID, "bot", "hi")
+			}
+		}()
+	}
+	wg.Wait()
+	var msgs []*Message
+	assert.Eventually(t, func() bool {
+		msgs, _ = hub.History(rm.ID, 0, 100)
+		return len(msgs) == 80
+	}, time.Second, 10*time.Millisecond)
+	for i := 1; i < len(msgs); i++ {
+		assert.Less(t, msgs[i-1].ID, msgs[i].ID)
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	first := nextMessageID()
+	assert.Greater(t, nextMessageID(), first)
+	// ids of a later run are greater
+	assert.GreaterOrEqual(t, first, uint64(time.Now().Add(-time.Minute).UnixMicro()))
+	assert.Less(t, first, uint64(1)<<53)
+}
+
+func TestRoomInfoConcurrent(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "alice")
+	assert.NoError(t, err)
+
+	r, _ := hub.findRoom(rm.ID)
+	for i := 0; i < 100; i++ {
+		r.remember(&Message{ID: uint64(i), Type: T_MESSAGE})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			topic := strconv.Itoa(i)
+			hub.UpdateRoom(rm.ID, "alice", &RoomUpdate{Topic: &topic, Pin: []uint64{uint64(i)}, Mute: []string{E_TOPIC}})
+			hub.RenameRoom(rm.ID, "alice", "general "+topic)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			hub.RoomList()
+			hub.GetRoom(rm.ID)
+		}
+	}()
+	wg.Wait()
+	assert.Equal(t, "99", hub.GetRoom(rm.ID).Topic)
+}
+
+func TestRoomEventMuted(t *testing.T) {
+	r := newRoom(RoomInfo{ID: "1", Name: "general", Slug: "general"}, nil)
+
+	msg := r.event(E_JOIN, "alice", "")
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, T_SYSTEM, msg.Type)
+		assert.Equal(t, "alice joined", msg.Data)
+		assert.Equal(t, map[string]string{"user": "alice"}, msg.Meta)
+	}
+
+	events, changed, err := r.update("alice", &RoomUpdate{Mute: []string{E_JOIN, E_LEAVE}})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Empty(t, events)
+	assert.Nil(t, r.event(E_JOIN, "bob", ""))
+
+	r.update("alice", &RoomUpdate{Unmute: []string{E_JOIN}})
+	assert.Equal(t, []string{E_LEAVE}, r.Muted)
+	assert.NotNil(t, r.event(E_JOIN, "bob", ""))
+}
+
+func TestRoomHistory(t *testing.T) {
+	r := newRoom(RoomInfo{ID: "1", Name: "general", Slug: "general"}, nil)
+	r.historySize = 3
+	for id := uint64(1); id <= 5; id++ {
+		r.remember(&Message{ID: id, Type: T_MESSAGE})
+	}
+
+	ids := func(msgs []*Message) []uint64 {
+		res := []uint64{}
+		for _, msg := range msgs {
+			res = append(res, msg.ID)
+		}
+		return res
+	}
+	assert.Equal(t, []uint64{3, 4, 5}, ids(r.recent(0, 10)))
+	assert.Equal(t, []uint64{4, 5}, ids(r.recent(0, 2)))
+	assert.Equal(t, []uint64{3, 4}, ids(r.recent(5, 10)))
+	assert.Equal(t, []uint64{}, ids(r.recent(3, 10)))
+
+	r.historySize = 0
+	r.remember(&Message{ID: 6})
+	assert.Equal(t, []uint64{3, 4, 5}, ids(r.recent(0, 10)))
+}
@@ -0,0 +1,11 @@
+package chat
+
+import "../logging"
+
+// loggers of chat subsystems
+var (
+	hubLog    = logging.Logger(logging.Hub)
+	roomLog   = logging.Logger(logging.Room)
+	clientLog = logging.Logger(logging.Client)
+	queueLog  = logging.Logger(logging.Queue)
+)
@@ -1,24 +1,43 @@
 package chat
 
 const (
-	T_JOIN    = "JOIN"    // c -> s, client join room
-	T_LEAVE   = "LEAVE"   // c -> s, client leave room
-	T_CREATE  = "CREATE"  // c -> s, client room
-	T_CLOSE   = "CLOSE"   // s -> c, room closed
-	T_ROOMS   = "ROOMS"   // c -> s, get room list
-	T_MESSAGE = "MESSAGE" // c <-> s, messge
+	T_JOIN    = "JOIN"        // c -> s, client join room
+	T_LEAVE   = "LEAVE"       // c -> s, client leave room
+	T_CREATE  = "CREATE"      // c -> s, client room
+	T_CLOSE   = "CLOSE"       // s -> c, room closed
+	T_ROOMS   = "ROOMS"       // c -> s, get room list
+	T_RENAME  = "RENAME"      // c -> s, rename room
+	T_UPDATE  = "UPDATE_ROOM" // c -> s, update room metadata, owner only
+	T_MESSAGE = "MESSAGE"     // c <-> s, messge
+	T_SYSTEM  = "SYSTEM"      // s -> c, room event generated by server
+	T_ERROR   = "ERROR"       // s -> c, request failed
+)
+
+// system event types, carried by T_SYSTEM message
+const (
+	E_JOIN   = "join"   // user joined room
+	E_LEAVE  = "leave"  // user left room
+	E_RENAME = "rename" // room renamed
+	E_TOPIC  = "topic"  // room topic changed
+	E_DESC   = "desc"   // room description changed
+	E_AVATAR = "avatar" // room avatar changed
+	E_PIN    = "pin"    // message pinned
+	E_UNPIN  = "unpin"  // message unpinned
 )
 
 // Message receive/send to websocket client
 //
 type Message struct {
-	ID        uint64 `json:"id,omitempty"`        // message id
-	Type      string `json:"type,omitempty"`      // message type
-	From      string `json:"from,omitempty"`      // message from client id
-	Room      string `json:"room,omitempty"`      // which room this message sends to
-	Timestamp int64  `json:"timestamp,omitempty"` // message timestamp
-	Data      string `json:"data,omitempty"`      // message data
-	Discard   bool   `json:"-"`                   // discard this message, set by handler
+	ID        uint64            `json:"id,omitempty"`        // message id
+	Type      string            `json:"type,omitempty"`      // message type
+	From      string            `json:"from,omitempty"`      // message from client id
+	Room      string            `json:"room,omitempty"`      // which room this message sends to
+	Timestamp int64             `json:"timestamp,omitempty"` // message timestamp
+	Data      string            `json:"data,omitempty"`      // message data
+	Event     string            `json:"event,omitempty"`     // system event type
+	Meta      map[string]string `json:"meta,omitempty"`      // system event arguments
+	Discard   bool              `json:"-"`                   // discard this message, set by handler
+	Source    Conn              `json:"-"`                   // connection message was read from, or join/leave event is of
 }
 
 // The MessageHandler type is an adapter to allow the use of
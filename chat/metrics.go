@@ -0,0 +1,200 @@
+package chat
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// websocket error kinds
+const (
+	ErrKindRejected = "rejected" // refused by connect hooks
+	ErrKindUpgrade  = "upgrade"  // handshake failed
+	ErrKindRead     = "read"     // unexpected read failure
+	ErrKindWrite    = "write"    // unexpected write failure
+	ErrKindPing     = "ping"     // heartbeat failed
+)
+
+// reasons of dropped messages
+const (
+	dropClosed    = "queue_closed" // client queue closed
+	dropDiscarded = "discarded"    // discarded by handlers
+)
+
+// Metrics of a room hub, it's a prometheus collector. Gauges of clients,
+// rooms and queues are read from the hub when collected.
+type Metrics struct {
+	hub *RoomHub
+
+	received  *prometheus.CounterVec
+	sent      *prometheus.CounterVec
+	dropped   *prometheus.CounterVec
+	wsErrors  *prometheus.CounterVec
+	limited   *prometheus.CounterVec
+	broadcast prometheus.Histogram
+	pingRTT   prometheus.Histogram
+
+	clients   *prometheus.Desc
+	rooms     *prometheus.Desc
+	members   *prometheus.Desc
+	roomQueue *prometheus.Desc
+	queueMax  *prometheus.Desc
+	queueSum  *prometheus.Desc
+}
+
+func newMetrics(h *RoomHub) *Metrics {
+	return &Metrics{
+		hub: h,
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sparrow_messages_received_total",
+			Help: "Messages received from clients by type.",
+		}, []string{"type"}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sparrow_messages_sent_total",
+			Help: "Messages sent to clients by type.",
+		}, []string{"type"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sparrow_messages_dropped_total",
+			Help: "Messages dropped by reason.",
+		}, []string{"reason"}),
+		wsErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sparrow_websocket_errors_total",
+			Help: "Websocket errors by kind.",
+		}, []string{"kind"}),
+		limited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sparrow_ratelimit_total",
+			Help: "Rate limiter rejections, mutes and disconnections by action.",
+		}, []string{"action"}),
+		broadcast: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "sparrow_broadcast_fanout_seconds",
+			Help:    "Time to push a room message to all room members.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}),
+		pingRTT: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "sparrow_ping_rtt_seconds",
+			Help:    "Round trip time of websocket pings.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}),
+		clients: prometheus.NewDesc("sparrow_clients",
+			"Connected clients.", nil, nil),
+		rooms: prometheus.NewDesc("sparrow_rooms",
+			"Active rooms.", nil, nil),
+		members: prometheus.NewDesc("sparrow_room_members",
+			"Online members of room.", []string{"room"}, nil),
+		roomQueue: prometheus.NewDesc("sparrow_room_queue_length",
+			"Messages waiting to be broadcast in room.", []string{"room"}, nil),
+		queueMax: prometheus.NewDesc("sparrow_client_queue_length_max",
+			"Most messages waiting to be sent to a client.", nil, nil),
+		queueSum: prometheus.NewDesc("sparrow_client_queue_length_sum",
+			"Messages waiting to be sent to all clients.", nil, nil),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.received, m.sent, m.dropped, m.wsErrors, m.limited, m.broadcast, m.pingRTT}
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+	ch <- m.clients
+	ch <- m.rooms
+	ch <- m.members
+	ch <- m.roomQueue
+	ch <- m.queueMax
+	ch <- m.queueSum
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+
+	// queues are summed up, a series per client would grow without bound
+	clients, queueMax, queueSum := 0, 0, 0
+	m.hub.clients.Range(func(key, value interface{}) bool {
+		if c, ok := value.(*Client); ok {
+			clients++
+			n := c.msgs.Len()
+			queueSum += n
+			if n > queueMax {
+				queueMax = n
+			}
+		}
+		return true
+	})
+	ch <- prometheus.MustNewConstMetric(m.clients, prometheus.GaugeValue, float64(clients))
+	ch <- prometheus.MustNewConstMetric(m.queueMax, prometheus.GaugeValue, float64(queueMax))
+	ch <- prometheus.MustNewConstMetric(m.queueSum, prometheus.GaugeValue, float64(queueSum))
+
+	rooms := 0
+	m.hub.rooms.Range(func(key, value interface{}) bool {
+		if r, ok := value.(*room); ok {
+			rooms++
+			ch <- prometheus.MustNewConstMetric(m.members, prometheus.GaugeValue,
+				float64(atomic.LoadInt32(&r.CCount)), r.ID)
+			ch <- prometheus.MustNewConstMetric(m.roomQueue, prometheus.GaugeValue,
+				float64(r.broadcast.Len()), r.ID)
+		}
+		return true
+	})
+	ch <- prometheus.MustNewConstMetric(m.rooms, prometheus.GaugeValue, float64(rooms))
+}
+
+// msgType return message type as label, unknown types are folded
+// so clients can't blow up label cardinality.
+func msgType(typ string) string {
+	switch typ {
+	case T_JOIN, T_LEAVE, T_CREATE, T_CLOSE, T_ROOMS, T_RENAME, T_UPDATE, T_MESSAGE, T_SYSTEM, T_ERROR:
+		return typ
+	}
+	return "other"
+}
+
+// methods below are safe on nil metrics, clients may have no hub
+
+func (m *Metrics) messageReceived(typ string) {
+	if m != nil {
+		m.received.WithLabelValues(msgType(typ)).Inc()
+	}
+}
+
+func (m *Metrics) messageSent(typ string) {
+	if m != nil {
+		m.sent.WithLabelValues(msgType(typ)).Inc()
+	}
+}
+
+func (m *Metrics) messageDropped(reason string) {
+	if m != nil {
+		m.dropped.WithLabelValues(reason).Inc()
+	}
+}
+
+func (m *Metrics) websocketError(kind string) {
+	if m != nil {
+		m.wsErrors.WithLabelValues(kind).Inc()
+	}
+}
+
+func (m *Metrics) rateLimited(action string) {
+	if m != nil {
+		m.limited.WithLabelValues(action).Inc()
+	}
+}
+
+func (m *Metrics) fanout(d time.Duration) {
+	if m != nil {
+		m.broadcast.Observe(d.Seconds())
+	}
+}
+
+func (m *Metrics) ping(rtt time.Duration) {
+	if m != nil {
+		m.pingRTT.Observe(rtt.Seconds())
+	}
+}
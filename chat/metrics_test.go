@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func histogramCount(h prometheus.Histogram) uint64 {
+	var m dto.Metric
+	h.Write(&m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetrics(t *testing.T) {
+	hub := NewChatHub()
+	opts := DefaultOptions
+	opts.PongWait = 100 * time.Millisecond
+	hub.SetOptions(opts)
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(hub.ServeWebsocket))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	var reply Message
+	assert.NoError(t, conn.WriteJSON(&Message{Type: T_JOIN, From: "alice", Room: "general"}))
+	assert.NoError(t, conn.WriteJSON(&Message{Type: T_MESSAGE, From: "alice", Room: rm.ID, Data: "hi"}))
+	assert.NoError(t, conn.WriteJSON(&Message{Type: "BOGUS", From: "alice"}))
+	for reply.Type != T_MESSAGE {
+		assert.NoError(t, conn.ReadJSON(&reply))
+	}
+	// pongs are sent while reading
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	m := hub.Metrics()
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(m.received.WithLabelValues(T_JOIN)) == 1 &&
+			testutil.ToFloat64(m.received.WithLabelValues(T_MESSAGE)) == 1 &&
+			testutil.ToFloat64(m.received.WithLabelValues("other")) == 1 &&
+			testutil.ToFloat64(m.sent.WithLabelValues(T_MESSAGE)) == 1 &&
+			histogramCount(m.broadcast) == 1 &&
+			histogramCount(m.pingRTT) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	reg := prometheus.NewRegistry()
+	assert.NoError(t, reg.Register(m))
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP sparrow_clients Connected clients.
+# TYPE sparrow_clients gauge
+sparrow_clients 1
+# HELP sparrow_rooms Active rooms.
+# TYPE sparrow_rooms gauge
+sparrow_rooms 1
+# HELP sparrow_room_members Online members of room.
+# TYPE sparrow_room_members gauge
+sparrow_room_members{room="`+rm.ID+`"} 1
+`), "sparrow_clients", "sparrow_rooms", "sparrow_room_members"))
+	assert.Equal(t, 1, testutil.CollectAndCount(m, "sparrow_client_queue_length_max"))
+	assert.Equal(t, 1, testutil.CollectAndCount(m, "sparrow_client_queue_length_sum"))
+
+	// closed queues drop messages
+	c := newClient(hub, "test")
+	c.msgs.Close()
+	assert.False(t, c.PushMessage(&Message{Type: T_MESSAGE}))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.dropped.WithLabelValues(dropClosed)))
+}
@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy decides which web pages may open connections to the hub,
+// so other sites can't talk to the hub with cookies of our users.
+// Pages served by the hub itself are always allowed.
+type OriginPolicy struct {
+	// Allowed origins besides the request host, each one is a host
+	// "chat.lan", a host with port "chat.lan:9090", a wildcard of
+	// subdomains "*.example.com", or an origin with scheme "https://chat.lan".
+	Allowed []string
+
+	// Dev allows any origin, for local development only
+	Dev bool
+}
+
+// Check if the request origin is allowed, requests without origin
+// are sent by non-browser clients, so they are allowed.
+func (p *OriginPolicy) Check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.Dev {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		hubLog.Warn("websocket origin invalid, rejected", "origin", origin)
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, pattern := range p.Allowed {
+		if matchOrigin(pattern, u) {
+			return true
+		}
+	}
+
+	hubLog.Warn("websocket origin not allowed, rejected", "origin", origin, "host", r.Host, "addr", r.RemoteAddr)
+	return false
+}
+
+// matchOrigin check if origin matches the allowed pattern
+func matchOrigin(pattern string, origin *url.URL) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		if pattern[:i] != strings.ToLower(origin.Scheme) {
+			return false
+		}
+		pattern = pattern[i+3:]
+	}
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	host := strings.ToLower(origin.Host)
+	if _, _, err := net.SplitHostPort(pattern); err != nil {
+		// pattern without port matches any port
+		host = strings.ToLower(origin.Hostname())
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
@@ -0,0 +1,52 @@
+package chat
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	p := &OriginPolicy{Allowed: []string{"*.example.com", "chat.lan:8080", "https://secure.lan"}}
+
+	check := func(origin string) bool {
+		r := httptest.NewRequest("GET", "http://sparrow.lan:9090/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return p.Check(r)
+	}
+
+	assert.True(t, check(""))
+	assert.True(t, check("http://sparrow.lan:9090"))
+	assert.True(t, check("https://a.example.com"))
+	assert.True(t, check("http://a.b.example.com:3000"))
+	assert.False(t, check("http://example.com"))
+	assert.False(t, check("http://evilexample.com"))
+	assert.True(t, check("http://chat.lan:8080"))
+	assert.False(t, check("http://chat.lan:8081"))
+	assert.True(t, check("https://secure.lan"))
+	assert.False(t, check("http://secure.lan"))
+	assert.False(t, check("http://sparrow.lan:9091"))
+	assert.False(t, check("null"))
+
+	p.Dev = true
+	assert.True(t, check("http://evil.com"))
+}
+
+func TestServeWebsocketOrigin(t *testing.T) {
+	hub := NewChatHub()
+	hub.SetOriginPolicy(OriginPolicy{Allowed: []string{"chat.lan"}})
+
+	r := httptest.NewRequest("GET", "http://sparrow.lan/ws", nil)
+	r.Header.Set("Connection", "upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-Websocket-Version", "13")
+	r.Header.Set("Sec-Websocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Origin", "http://evil.com")
+
+	w := httptest.NewRecorder()
+	hub.ServeWebsocket(w, r)
+	assert.Equal(t, 403, w.Code)
+}
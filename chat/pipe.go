@@ -0,0 +1,158 @@
+package chat
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// inprocSeq numbers in-memory connections
+var inprocSeq uint64
+
+// inprocAddr return unique address of an in-memory connection, e.g.
+// "pipe-1", limits of rate limiter per remote ip apply to each one alone,
+// not to all bots of the process together. It has no port, so it is the
+// ip key as a whole.
+func inprocAddr(kind string) string {
+	return kind + "-" + strconv.FormatUint(atomic.AddUint64(&inprocSeq, 1), 10)
+}
+
+// closer is shared by both ends of in-memory connections
+type closer struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newCloser() *closer {
+	return &closer{closed: make(chan struct{})}
+}
+
+func (c *closer) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// pipeConn is one end of an in-memory pipe
+type pipeConn struct {
+	*closer
+	addr string
+	in   <-chan *Message
+	out  chan<- *Message
+}
+
+// Pipe create an in-memory connection, messages written to one end are
+// read from the other one. Serve one end by hub.Connect and talk to the
+// hub with the other one, like a websocket peer without sockets.
+// Closing either end closes both.
+func Pipe() (Conn, Conn) {
+	c := newCloser()
+	addr := inprocAddr("pipe")
+	a, b := make(chan *Message), make(chan *Message)
+	return &pipeConn{closer: c, addr: addr, in: a, out: b},
+		&pipeConn{closer: c, addr: addr, in: b, out: a}
+}
+
+func (c *pipeConn) ReadMessage() (*Message, error) {
+	select {
+	case msg := <-c.in:
+		return msg, nil
+	case <-c.closed:
+		return nil, io.EOF
+	}
+}
+
+// WriteMessage block until peer reads the message, peer gets a copy,
+// as messages from hub are shared by all clients in the room.
+func (c *pipeConn) WriteMessage(msg *Message) error {
+	cp := *msg
+	select {
+	case c.out <- &cp:
+		return nil
+	case <-c.closed:
+		return io.EOF
+	}
+}
+
+func (c *pipeConn) Ping() error {
+	select {
+	case <-c.closed:
+		return io.EOF
+	default:
+		return nil
+	}
+}
+
+func (c *pipeConn) RemoteAddr() string {
+	return c.addr
+}
+
+// Loopback is the connection of a peer living in the hub's process,
+// e.g. a bot. Messages from hub are passed to its handler, and messages
+// sent by Send reach the hub as if the peer wrote them.
+type Loopback struct {
+	*closer
+	addr   string
+	handle func(msg *Message)
+	in     chan *Message
+}
+
+// NewLoopback create loopback connection calling handle for messages
+// from hub, handle is called by one goroutine and may call Send.
+func NewLoopback(handle func(msg *Message)) *Loopback {
+	return &Loopback{
+		closer: newCloser(),
+		addr:   inprocAddr("loopback"),
+		handle: handle,
+		in:     make(chan *Message),
+	}
+}
+
+// Send message to hub, as if peer wrote it
+func (l *Loopback) Send(msg *Message) error {
+	select {
+	case l.in <- msg:
+		return nil
+	case <-l.closed:
+		return ErrConnClosed
+	}
+}
+
+// ReadMessage return next message sent by Send
+func (l *Loopback) ReadMessage() (*Message, error) {
+	select {
+	case msg := <-l.in:
+		return msg, nil
+	case <-l.closed:
+		return nil, io.EOF
+	}
+}
+
+// WriteMessage pass a copy of message to handler
+func (l *Loopback) WriteMessage(msg *Message) error {
+	select {
+	case <-l.closed:
+		return io.EOF
+	default:
+	}
+	cp := *msg
+	l.handle(&cp)
+	return nil
+}
+
+// Ping fail once the connection has been closed
+func (l *Loopback) Ping() error {
+	select {
+	case <-l.closed:
+		return io.EOF
+	default:
+		return nil
+	}
+}
+
+// RemoteAddr return unique address of the loopback, e.g. "loopback-1"
+func (l *Loopback) RemoteAddr() string {
+	return l.addr
+}
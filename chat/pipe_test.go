@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readType read messages of peer until one of type typ
+func readType(t *testing.T, conn Conn, typ string) *Message {
+	for {
+		msg, err := conn.ReadMessage()
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+func TestPipe(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	local, alice := Pipe()
+	ca, err := hub.Connect(local)
+	assert.NoError(t, err)
+	assert.Contains(t, ca.RemoteAddr(), "pipe-")
+	local, bob := Pipe()
+	cb, _ := hub.Connect(local)
+	// limits per ip don't lump bots together
+	assert.NotEqual(t, ca.RemoteAddr(), cb.RemoteAddr())
+	assert.Equal(t, ca.RemoteAddr(), remoteIP(ca.RemoteAddr()))
+	assert.Len(t, hub.Clients(), 2)
+
+	assert.NoError(t, alice.WriteMessage(&Message{Type: T_JOIN, From: "alice", Room: "general"}))
+	assert.Equal(t, rm.ID, readType(t, alice, T_JOIN).Data)
+	assert.NoError(t, bob.WriteMessage(&Message{Type: T_JOIN, From: "bob", Room: "general"}))
+	readType(t, bob, T_JOIN)
+
+	assert.NoError(t, alice.WriteMessage(&Message{ID: 1, Type: T_MESSAGE, From: "alice", Room: rm.ID, Data: "hi"}))
+	msg := readType(t, bob, T_MESSAGE)
+	assert.Equal(t, "alice", msg.From)
+	assert.Equal(t, "hi", msg.Data)
+	assert.NotEqual(t, uint64(0), msg.ID)
+	assert.NotEqual(t, uint64(1), msg.ID, "id is assigned by server")
+
+	// closing peer end disconnects client
+	alice.Close()
+	assert.Eventually(t, func() bool {
+		return hub.GetClient(ca.ID()) == nil
+	}, time.Second, 10*time.Millisecond)
+	clients, err := hub.RoomClients(rm.ID)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+}
+
+func TestLoopback(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	// bot answers messages asking for it
+	var bot *Loopback
+	bot = NewLoopback(func(msg *Message) {
+		if msg.Type == T_MESSAGE && msg.From != "bot" && strings.Contains(msg.Data, "@bot") {
+			bot.Send(&Message{Type: T_MESSAGE, From: "bot", Room: msg.Room, Data: "hello " + msg.From})
+		}
+	})
+	hub.Connect(bot)
+	assert.NoError(t, bot.Send(&Message{Type: T_JOIN, From: "bot", Room: "general"}))
+
+	local, alice := Pipe()
+	hub.Connect(local)
+	assert.NoError(t, alice.WriteMessage(&Message{Type: T_JOIN, From: "alice", Room: "general"}))
+	readType(t, alice, T_JOIN)
+	assert.Eventually(t, func() bool {
+		clients, _ := hub.RoomClients(rm.ID)
+		return len(clients) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, alice.WriteMessage(&Message{Type: T_MESSAGE, From: "alice", Room: rm.ID, Data: "@bot hi"}))
+	msg := readType(t, alice, T_MESSAGE)
+	for msg != nil && msg.From != "bot" {
+		msg = readType(t, alice, T_MESSAGE)
+	}
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "hello alice", msg.Data)
+	}
+
+	bot.Close()
+	assert.Equal(t, ErrConnClosed, bot.Send(&Message{Type: T_ROOMS}))
+	assert.Eventually(t, func() bool {
+		return len(hub.Clients()) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestUserBound(t *testing.T) {
+	hub := NewChatHub()
+	rm, err := hub.NewRoom("general", "alice")
+	assert.NoError(t, err)
+
+	local, bob := Pipe()
+	c, err := hub.Connect(local)
+	assert.NoError(t, err)
+	assert.NoError(t, bob.WriteMessage(&Message{Type: T_JOIN, From: "bob", Room: "general"}))
+	readType(t, bob, T_JOIN)
+	assert.Equal(t, "bob", c.User())
+
+	// claiming to be the owner changes nothing
+	assert.NoError(t, bob.WriteMessage(&Message{Type: T_RENAME, From: "alice", Room: rm.ID, Data: "lobby"}))
+	assert.Equal(t, ErrNotOwner.Error(), readType(t, bob, T_ERROR).Data)
+	assert.NoError(t, bob.WriteMessage(&Message{Type: T_MESSAGE, From: "alice", Room: rm.ID, Data: "hi"}))
+	assert.Equal(t, "bob", readType(t, bob, T_MESSAGE).From)
+	assert.Equal(t, "bob", c.User())
+}
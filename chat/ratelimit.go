@@ -0,0 +1,247 @@
+package chat
+
+import (
+	"../std"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limits of client messages and connections, zero means unlimited
+type Limits struct {
+	MessageRate     float64       `toml:"message_rate" yaml:"message_rate"`         // messages per second of each ip
+	MessageBurst    int           `toml:"message_burst" yaml:"message_burst"`       // messages allowed in a burst
+	RoomsPerHour    int           `toml:"rooms_per_hour" yaml:"rooms_per_hour"`     // room creations per hour of each ip
+	MaxConnsPerIP   int           `toml:"max_conns_per_ip" yaml:"max_conns_per_ip"` // concurrent connections of each ip
+	MuteAfter       int           `toml:"mute_after" yaml:"mute_after"`             // violations before offender is muted
+	MuteDuration    time.Duration `toml:"mute_duration" yaml:"mute_duration"`       // how long offender is muted
+	DisconnectAfter int           `toml:"disconnect_after" yaml:"disconnect_after"` // violations before offender is disconnected
+}
+
+// DefaultLimits is generous enough for humans chatting on the LAN
+var DefaultLimits = Limits{
+	MessageRate:     5,
+	MessageBurst:    10,
+	RoomsPerHour:    10,
+	MaxConnsPerIP:   32,
+	MuteAfter:       5,
+	MuteDuration:    time.Minute,
+	DisconnectAfter: 20,
+}
+
+var (
+	// ErrRateLimited client sends too fast
+	ErrRateLimited = errors.New("rate limit exceeded")
+
+	// ErrMuted client has been muted for flooding
+	ErrMuted = errors.New("muted for flooding")
+
+	// ErrTooManyConns too many concurrent connections of the ip
+	ErrTooManyConns = errors.New("too many connections")
+)
+
+// violations older than this are forgotten
+const violationWindow = time.Minute
+
+type offender struct {
+	count int       // violations in window
+	last  time.Time // last violation
+	muted time.Time // muted until
+}
+
+// RateLimiter limits messages, room creations and concurrent connections of
+// each remote ip. Repeat offenders are muted for a while, and disconnected if
+// they keep flooding. Names in messages are not keyed on, they aren't
+// verified and anyone could get another user muted by taking the name.
+type RateLimiter struct {
+	lck       sync.Mutex
+	limits    Limits
+	msgs      *std.Limiter
+	rooms     *std.Limiter
+	conns     map[string]int    // connections of ip key
+	clients   map[uint64]string // ip key of connected clients
+	offenders map[string]*offender
+	swept     time.Time
+	vars      *expvar.Map
+	metrics   *Metrics // of hub installed to, nil before Install
+}
+
+// NewRateLimiter create rate limiter with given limits
+func NewRateLimiter(limits Limits) *RateLimiter {
+	rl := &RateLimiter{
+		msgs:      std.NewLimiter(0, 0),
+		rooms:     std.NewLimiter(0, 0),
+		conns:     make(map[string]int),
+		clients:   make(map[uint64]string),
+		offenders: make(map[string]*offender),
+		swept:     time.Now(),
+		vars:      new(expvar.Map).Init(),
+	}
+	rl.SetLimits(limits)
+	return rl
+}
+
+// SetLimits change limits, message and room buckets are reset
+func (rl *RateLimiter) SetLimits(limits Limits) {
+	rl.lck.Lock()
+	defer rl.lck.Unlock()
+
+	rl.limits = limits
+	rl.msgs.SetRate(limits.MessageRate, limits.MessageBurst)
+	rl.rooms.SetRate(float64(limits.RoomsPerHour)/3600, limits.RoomsPerHour)
+}
+
+// Limits return current limits
+func (rl *RateLimiter) Limits() Limits {
+	rl.lck.Lock()
+	defer rl.lck.Unlock()
+	return rl.limits
+}
+
+// Vars return counters of rejected requests, publish them with expvar
+func (rl *RateLimiter) Vars() *expvar.Map {
+	return rl.vars
+}
+
+// Install limiter to room hub, counters are reported by metrics of hub too
+func (rl *RateLimiter) Install(h *RoomHub) {
+	rl.lck.Lock()
+	rl.metrics = h.Metrics()
+	rl.lck.Unlock()
+	h.OnConnect(rl.connect)
+	h.OnDisconnect(rl.disconnect)
+	h.Use(PriorityFirst, rl.middleware)
+}
+
+func (rl *RateLimiter) connect(c *Client, r *http.Request) error {
+	addr := c.addr
+	if r != nil {
+		addr = r.RemoteAddr
+	}
+	ip := "ip:" + remoteIP(addr)
+
+	rl.lck.Lock()
+	defer rl.lck.Unlock()
+
+	if max := rl.limits.MaxConnsPerIP; max > 0 && rl.conns[ip] >= max {
+		rl.count("rejected_connections")
+		return ErrTooManyConns
+	}
+	rl.conns[ip]++
+	rl.clients[c.id] = ip
+	return nil
+}
+
+func (rl *RateLimiter) disconnect(c *Client) {
+	rl.lck.Lock()
+	defer rl.lck.Unlock()
+
+	ip, ok := rl.clients[c.id]
+	if !ok {
+		return
+	}
+	delete(rl.clients, c.id)
+	if rl.conns[ip]--; rl.conns[ip] <= 0 {
+		delete(rl.conns, ip)
+	}
+}
+
+func (rl *RateLimiter) middleware(next HandlerFunc) HandlerFunc {
+	return func(ctx *Context) error {
+		if ctx.Client != nil {
+			if err := rl.check(ctx.Client, ctx.Message); err != nil {
+				return err
+			}
+		}
+		return next(ctx)
+	}
+}
+
+// check if client is allowed to send the message
+func (rl *RateLimiter) check(c *Client, msg *Message) error {
+	disconnect, err := rl.allow(c, msg)
+	// closing takes locks of client and hub, not under limiter lock
+	if disconnect {
+		c.Close()
+	}
+	return err
+}
+
+// allow check message of client, and if client should be disconnected
+func (rl *RateLimiter) allow(c *Client, msg *Message) (bool, error) {
+	now := time.Now()
+	key := "ip:" + remoteIP(c.addr)
+
+	rl.lck.Lock()
+	defer rl.lck.Unlock()
+
+	// keep counting violations of muted offender, until it's disconnected
+	if o := rl.offenders[key]; o != nil && now.Before(o.muted) {
+		rl.count("rejected_messages")
+		return rl.violate(key, now)
+	}
+
+	allowed := rl.msgs.Allow(key)
+	if allowed && msg.Type == T_CREATE {
+		if allowed = rl.rooms.Allow(key); !allowed {
+			rl.count("rejected_rooms")
+		}
+	}
+	if allowed {
+		return false, nil
+	}
+
+	rl.count("rejected_messages")
+	return rl.violate(key, now)
+}
+
+// count rejection in vars and metrics
+func (rl *RateLimiter) count(name string) {
+	rl.vars.Add(name, 1)
+	rl.metrics.rateLimited(name)
+}
+
+// violate record violation of offender, mute or disconnect repeat offender
+func (rl *RateLimiter) violate(key string, now time.Time) (bool, error) {
+	if now.Sub(rl.swept) > violationWindow {
+		for k, o := range rl.offenders {
+			if now.Sub(o.last) > violationWindow && now.After(o.muted) {
+				delete(rl.offenders, k)
+			}
+		}
+		rl.swept = now
+	}
+
+	o, ok := rl.offenders[key]
+	if !ok || (now.Sub(o.last) > violationWindow && now.After(o.muted)) {
+		o = &offender{}
+		rl.offenders[key] = o
+	}
+	o.count++
+	o.last = now
+
+	if n := rl.limits.DisconnectAfter; n > 0 && o.count >= n {
+		rl.count("disconnected")
+		return true, ErrRateLimited
+	}
+	if now.Before(o.muted) {
+		return false, ErrMuted
+	}
+	if n := rl.limits.MuteAfter; n > 0 && o.count >= n {
+		rl.count("muted")
+		o.muted = now.Add(rl.limits.MuteDuration)
+		return false, ErrMuted
+	}
+	return false, ErrRateLimited
+}
+
+// remoteIP strip port from remote address
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitMessages(t *testing.T) {
+	rl := NewRateLimiter(Limits{
+		MessageRate:  1,
+		MessageBurst: 2,
+		MuteAfter:    2,
+		MuteDuration: time.Minute,
+	})
+	c := newClient(nil, "10.0.0.1:5000")
+	msg := &Message{Type: T_MESSAGE, From: "alice"}
+
+	assert.NoError(t, rl.check(c, msg))
+	assert.NoError(t, rl.check(c, msg))
+	assert.Equal(t, ErrRateLimited, rl.check(c, msg))
+	assert.Equal(t, ErrMuted, rl.check(c, msg))
+
+	// muted user stays muted even when the bucket refills
+	rl.msgs.SetRate(1, 2)
+	assert.Equal(t, ErrMuted, rl.check(c, msg))
+	assert.Equal(t, "3", rl.Vars().Get("rejected_messages").String())
+
+	// flooding under the name of alice from another ip doesn't mute her
+	victim := newClient(nil, "10.0.0.2:5000")
+	assert.NoError(t, rl.check(victim, msg))
+}
+
+func TestRateLimitDisconnect(t *testing.T) {
+	hub := NewChatHub()
+	rl := NewRateLimiter(Limits{MessageRate: 1, MessageBurst: 1, DisconnectAfter: 2})
+	rl.Install(hub)
+	local, _ := Pipe()
+	c, err := hub.Connect(local)
+	assert.NoError(t, err)
+
+	msg := &Message{Type: T_MESSAGE, From: "mallory"}
+	assert.NoError(t, rl.check(c, msg))
+	assert.Equal(t, ErrRateLimited, rl.check(c, msg))
+	assert.Equal(t, ErrRateLimited, rl.check(c, msg))
+	assert.Eventually(t, func() bool {
+		return len(hub.Clients()) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1.0, testutil.ToFloat64(hub.Metrics().limited.WithLabelValues("disconnected")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(hub.Metrics().limited.WithLabelValues("rejected_messages")))
+}
+
+func TestRateLimitConnections(t *testing.T) {
+	rl := NewRateLimiter(Limits{MaxConnsPerIP: 1})
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+
+	c1 := newClient(nil, req.RemoteAddr)
+	c2 := newClient(nil, req.RemoteAddr)
+	assert.NoError(t, rl.connect(c1, req))
+	assert.Equal(t, ErrTooManyConns, rl.connect(c2, req))
+	rl.disconnect(c2)
+
+	// another ip, users are not counted as they aren't verified
+	req.RemoteAddr = "10.0.0.2:5000"
+	c3 := newClient(nil, req.RemoteAddr)
+	assert.NoError(t, rl.connect(c3, req))
+	assert.NoError(t, rl.check(c1, &Message{From: "alice"}))
+	assert.NoError(t, rl.check(c3, &Message{From: "alice"}))
+
+	rl.disconnect(c1)
+	rl.disconnect(c3)
+	assert.Empty(t, rl.conns)
+}
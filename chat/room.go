@@ -2,10 +2,11 @@ package chat
 
 import (
 	"../std"
+	"fmt"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
-
-	"github.com/go-clog/clog"
 )
 
 const (
@@ -17,8 +18,14 @@ const (
 type RoomInfo struct {
 	ID      string    `json:"id,omitempty"`         // Room ID
 	Name    string    `json:"name,omitempty"`       // Room name
+	Slug    string    `json:"slug,omitempty"`       // Normalized unique room name
+	Aliases []string  `json:"aliases,omitempty"`    // Slugs of former room names
+	Owner   string    `json:"owner,omitempty"`      // User who created the room
+	Topic   string    `json:"topic,omitempty"`      // Room topic
 	Desc    string    `json:"text,omitempty"`       // Room description
 	Avatar  string    `json:"avatar,omitempty"`     // Room avatar
+	Pinned  []uint64  `json:"pinned,omitempty"`     // Pinned message ids
+	Muted   []string  `json:"muted,omitempty"`      // System event types not broadcast
 	Active  bool      `json:"active,omitempty"`     // Room is still active or not
 	CCount  int32     `json:"clintCount,omitempty"` // Online client count
 	MCount  int32     `json:"msgCount,omitempty"`   // Room history message count
@@ -31,14 +38,42 @@ type room struct {
 	online    chan *Client       // clients to be online
 	offline   chan *Client       // clients to be offline
 	clients   map[uint64]*Client // all online clients
+	chain     chain              // room message handlers
 	broadcast std.Queue          // message to broadcast
 	quit      chan struct{}
+
+	lck         sync.RWMutex // guards room information and history
+	history     []*Message   // recent messages, oldest first
+	historySize int
+}
+
+// info return a snapshot of room information,
+// online count changes while clients come and go.
+func (r *room) info() *RoomInfo {
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+	return &RoomInfo{
+		ID:      r.ID,
+		Name:    r.Name,
+		Slug:    r.Slug,
+		Aliases: r.Aliases,
+		Owner:   r.Owner,
+		Topic:   r.Topic,
+		Desc:    r.Desc,
+		Avatar:  r.Avatar,
+		Pinned:  r.Pinned,
+		Muted:   r.Muted,
+		Active:  r.Active,
+		CCount:  atomic.LoadInt32(&r.CCount),
+		MCount:  atomic.LoadInt32(&r.MCount),
+		Updated: r.Updated,
+	}
 }
 
 func (r *room) run() {
 	defer func() {
 		r.broadcast.Close()
-		clog.Info("Room %s closed.", r.RoomInfo.ID)
+		roomLog.Info("room closed", "room", r.RoomInfo.ID)
 	}()
 
 	var (
@@ -48,15 +83,18 @@ func (r *room) run() {
 	for {
 		select {
 		case c := <-r.online:
-			r.clients[c.id] = c
-			r.CCount++
+			if _, ok := r.clients[c.id]; !ok {
+				r.clients[c.id] = c
+				atomic.AddInt32(&r.CCount, 1)
+				r.push(r.memberEvent(E_JOIN, c))
+			}
 			break
 
 		case c := <-r.offline:
 			if _, ok := r.clients[c.id]; ok {
 				delete(r.clients, c.id)
-				c.msgs.Close()
-				r.CCount--
+				atomic.AddInt32(&r.CCount, -1)
+				r.push(r.memberEvent(E_LEAVE, c))
 			}
 			break
 
@@ -67,8 +105,11 @@ func (r *room) run() {
 			if msg, ok = itm.(*Message); !ok {
 				break
 			}
-			for _, c := range r.clients {
-				c.PushMessage(msg)
+			start := time.Now()
+			r.push(msg)
+			r.hub.metrics.fanout(time.Since(start))
+			if msg.Type == T_MESSAGE {
+				r.remember(msg)
 			}
 			break
 
@@ -79,9 +120,10 @@ func (r *room) run() {
 				Type:      T_CLOSE,
 			}
 			for _, c := range r.clients {
+				c.rooms.Delete(r.ID)
 				if !c.PushMessage(msg) {
 					delete(r.clients, c.id)
-					r.CCount--
+					atomic.AddInt32(&r.CCount, -1)
 				}
 			}
 			return
@@ -92,34 +134,273 @@ func (r *room) run() {
 	}
 }
 
-func newRoom(name string, h *RoomHub) *room {
+// RoomUpdate changes of room metadata, nil fields are left untouched
+type RoomUpdate struct {
+	Topic  *string  `json:"topic,omitempty"`  // New room topic
+	Desc   *string  `json:"text,omitempty"`   // New room description
+	Avatar *string  `json:"avatar,omitempty"` // New room avatar
+	Pin    []uint64 `json:"pin,omitempty"`    // Message ids to pin, must be in room history
+	Unpin  []uint64 `json:"unpin,omitempty"`  // Message ids to unpin
+	Mute   []string `json:"mute,omitempty"`   // System event types to turn off
+	Unmute []string `json:"unmute,omitempty"` // System event types to turn on again
+}
+
+// eventText human readable text of system events,
+// formatted with user name and event value.
+var eventText = map[string]string{
+	E_JOIN:   "%[1]s joined",
+	E_LEAVE:  "%[1]s left",
+	E_RENAME: "%[1]s renamed the room to %[2]s",
+	E_TOPIC:  "%[1]s changed the topic to %[2]s",
+	E_DESC:   "%[1]s changed the description to %[2]s",
+	E_AVATAR: "%[1]s changed the avatar to %[2]s",
+	E_PIN:    "%[1]s pinned message %[2]s",
+	E_UNPIN:  "%[1]s unpinned message %[2]s",
+}
+
+func newRoom(info RoomInfo, h *RoomHub) *room {
+	opts := DefaultOptions
+	if h != nil {
+		opts = h.Options()
+	}
+	info.Active = true
 	r := &room{
-		RoomInfo: RoomInfo{
-			ID:     std.GenUIDs(),
-			Name:   name,
-			Desc:   name,
-			Active: true,
-		},
+		RoomInfo:  info,
 		hub:       h,
 		quit:      make(chan struct{}, 1),
 		online:    make(chan *Client, ch32),
 		offline:   make(chan *Client, ch32),
 		clients:   make(map[uint64]*Client),
-		broadcast: std.NewSyncQueue(maxQueueSize),
+		broadcast: std.NewSyncQueue(opts.MaxQueueSize),
+
+		historySize: opts.HistorySize,
 	}
-	go r.run()
 	return r
 }
 
+// lastMessageID is the last message id given, ids are microseconds since
+// epoch, or the last one plus one when more come in a microsecond, so they
+// keep rising after restarts and pinned ids never refer to newer messages.
+// They stay below 2^53, safe as numbers of javascript clients.
+var lastMessageID uint64
+
+// nextMessageID return id of a new message
+func nextMessageID() uint64 {
+	for {
+		last := atomic.LoadUint64(&lastMessageID)
+		id := uint64(time.Now().UnixMicro())
+		if id <= last {
+			id = last + 1
+		}
+		if atomic.CompareAndSwapUint64(&lastMessageID, last, id) {
+			return id
+		}
+	}
+}
+
+// remember add message to history, the oldest one is dropped when full
+func (r *room) remember(msg *Message) {
+	if r.historySize <= 0 {
+		return
+	}
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if len(r.history) >= r.historySize {
+		// copy, so slices returned by recent are never changed
+		r.history = append(r.history[:0:0], r.history[len(r.history)-r.historySize+1:]...)
+	}
+	r.history = append(r.history, msg)
+}
+
+// recent return at most limit messages sent before message id before,
+// oldest first, before 0 means the latest messages.
+func (r *room) recent(before uint64, limit int) []*Message {
+	r.lck.RLock()
+	history := r.history
+	r.lck.RUnlock()
+
+	end := len(history)
+	if before > 0 {
+		for end > 0 && history[end-1].ID >= before {
+			end--
+		}
+	}
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+	res := make([]*Message, end-start)
+	copy(res, history[start:end])
+	return res
+}
+
+// Broadcast queue message to room members, the message is given its id
+// here, in the order it's queued, so ids in history keep rising and
+// pages of recent cut at the right message.
 func (r *room) Broadcast(msg *Message) {
 	if msg != nil {
+		r.lck.Lock()
+		msg.ID = nextMessageID()
 		r.Updated = time.Now()
 		r.broadcast.Add(msg)
+		r.lck.Unlock()
 		atomic.AddInt32(&r.MCount, 1)
 	}
 }
 
+// push message to all online clients, called in room routine only
+func (r *room) push(msg *Message) {
+	if msg == nil {
+		return
+	}
+	for _, c := range r.clients {
+		c.PushMessage(msg)
+	}
+}
+
+// notify broadcast system event to members, it's not counted as room message
+func (r *room) notify(msg *Message) {
+	if msg != nil {
+		r.broadcast.Add(msg)
+	}
+}
+
+// event create system event message of this room,
+// return nil if the event type has been muted.
+func (r *room) event(event, user, value string) *Message {
+	r.lck.RLock()
+	muted := containsString(r.Muted, event)
+	r.lck.RUnlock()
+	if muted {
+		return nil
+	}
+
+	meta := map[string]string{"user": user}
+	if value != "" {
+		meta["value"] = value
+	}
+	return &Message{
+		Type:      T_SYSTEM,
+		Event:     event,
+		Room:      r.ID,
+		Timestamp: std.GetNowMs(),
+		Data:      fmt.Sprintf(eventText[event], user, value),
+		Meta:      meta,
+	}
+}
+
+// memberEvent create join or leave event of client, its source is the
+// connection of client, so gateways can tell own events from namesakes'.
+func (r *room) memberEvent(event string, c *Client) *Message {
+	msg := r.event(event, c.User(), "")
+	if msg != nil {
+		msg.Source = c.conn
+	}
+	return msg
+}
+
+// update apply changes to room metadata, return events of what has been
+// changed, and if anything changed. Nothing is changed if a message to pin
+// isn't in room history.
+func (r *room) update(user string, up *RoomUpdate) ([]*Message, bool, error) {
+	var (
+		changes [][2]string // event type and value, created after unlocked
+		changed bool
+	)
+	r.lck.Lock()
+	for _, id := range up.Pin {
+		if !containsID(r.Pinned, id) && !r.inHistory(id) {
+			r.lck.Unlock()
+			return nil, false, ErrMessageNotFound
+		}
+	}
+	if len(up.Mute) > 0 || len(up.Unmute) > 0 {
+		muted := make([]string, 0, len(r.Muted)+len(up.Mute))
+		for _, event := range r.Muted {
+			if !containsString(up.Unmute, event) {
+				muted = append(muted, event)
+			}
+		}
+		for _, event := range up.Mute {
+			if !containsString(muted, event) {
+				muted = append(muted, event)
+			}
+		}
+		r.Muted, changed = muted, true
+	}
+	if up.Topic != nil && *up.Topic != r.Topic {
+		r.Topic, changed = *up.Topic, true
+		changes = append(changes, [2]string{E_TOPIC, r.Topic})
+	}
+	if up.Desc != nil && *up.Desc != r.Desc {
+		r.Desc, changed = *up.Desc, true
+		changes = append(changes, [2]string{E_DESC, r.Desc})
+	}
+	if up.Avatar != nil && *up.Avatar != r.Avatar {
+		r.Avatar, changed = *up.Avatar, true
+		changes = append(changes, [2]string{E_AVATAR, r.Avatar})
+	}
+
+	// copy on write, so readers of room info never see a half updated list
+	pinned := make([]uint64, 0, len(r.Pinned)+len(up.Pin))
+	pinChanged := false
+	for _, id := range r.Pinned {
+		if !containsID(up.Unpin, id) {
+			pinned = append(pinned, id)
+		} else {
+			pinChanged = true
+			changes = append(changes, [2]string{E_UNPIN, strconv.FormatUint(id, 10)})
+		}
+	}
+	for _, id := range up.Pin {
+		if !containsID(pinned, id) {
+			pinned, pinChanged = append(pinned, id), true
+			changes = append(changes, [2]string{E_PIN, strconv.FormatUint(id, 10)})
+		}
+	}
+	if pinChanged {
+		r.Pinned, changed = pinned, true
+	}
+	r.lck.Unlock()
+
+	events := make([]*Message, 0, len(changes))
+	for _, change := range changes {
+		events = append(events, r.event(change[0], user, change[1]))
+	}
+	return events, changed, nil
+}
+
+// inHistory check if message is in room history, call it with lock held
+func (r *room) inHistory(id uint64) bool {
+	for _, msg := range r.history {
+		if msg.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(strs []string, str string) bool {
+	for _, v := range strs {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []uint64, id uint64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *room) Close() {
 	close(r.quit)
+	r.lck.Lock()
 	r.Active = false
+	r.lck.Unlock()
 }
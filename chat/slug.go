@@ -0,0 +1,28 @@
+package chat
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Slugify normalize room name to an unique lookup key,
+// letters and digits are lower cased, and other runs of characters
+// are folded into a single '-'.
+func Slugify(name string) string {
+	var (
+		sb   strings.Builder
+		dash bool
+	)
+	for _, r := range strings.TrimSpace(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(unicode.ToLower(r))
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return sb.String()
+}
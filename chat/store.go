@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persist rooms across server restarts
+type Store interface {
+	// LoadRooms return all saved rooms
+	LoadRooms() ([]*RoomInfo, error)
+
+	// SaveRoom create or update room
+	SaveRoom(info *RoomInfo) error
+
+	// DeleteRoom remove room from store
+	DeleteRoom(roomID string) error
+}
+
+// Pinger is implemented by stores which can check they are reachable,
+// the hub is not ready while the check fails.
+type Pinger interface {
+	Ping() error
+}
+
+type fileStore struct {
+	lck   sync.Mutex
+	path  string
+	rooms map[string]*RoomInfo
+}
+
+// NewFileStore create store which keeps all rooms in a json file
+func NewFileStore(path string) (Store, error) {
+	s := &fileStore{
+		path:  path,
+		rooms: make(map[string]*RoomInfo),
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var rooms []*RoomInfo
+	if err = json.Unmarshal(bs, &rooms); err != nil {
+		return nil, err
+	}
+	for _, info := range rooms {
+		s.rooms[info.ID] = info
+	}
+	return s, nil
+}
+
+func (s *fileStore) LoadRooms() ([]*RoomInfo, error) {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	res := make([]*RoomInfo, 0, len(s.rooms))
+	for _, info := range s.rooms {
+		cp := *info
+		res = append(res, &cp)
+	}
+	return res, nil
+}
+
+func (s *fileStore) SaveRoom(info *RoomInfo) error {
+	cp := *info
+	cp.CCount = 0
+
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	s.rooms[cp.ID] = &cp
+	return s.flush()
+}
+
+func (s *fileStore) DeleteRoom(roomID string) error {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+	if _, ok := s.rooms[roomID]; !ok {
+		return nil
+	}
+	delete(s.rooms, roomID)
+	return s.flush()
+}
+
+// Ping check the store directory is still writable
+func (s *fileStore) Ping() error {
+	f, err := ioutil.TempFile(filepath.Dir(s.path), ".ping")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// flush write rooms to a temporary file then replace the store file,
+// so a crash while writing never leaves a truncated store behind.
+func (s *fileStore) flush() error {
+	rooms := make([]*RoomInfo, 0, len(s.rooms))
+	for _, info := range s.rooms {
+		rooms = append(rooms, info)
+	}
+	bs, err := json.MarshalIndent(rooms, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"./chat"
+	"./discovery"
+	"./irc"
+	"./logging"
+	"./mqtt"
+	"./rpc"
+	"./telnet"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix of environment variables overriding config,
+// e.g. SPARROW_ADDR, SPARROW_LOG_LEVEL or SPARROW_LIMITS_MESSAGE_RATE.
+const envPrefix = "SPARROW"
+
+// Config of sparrow server, loaded from defaults, then the config file,
+// then SPARROW_* environment variables, then command line flags.
+type Config struct {
+	Addr          string            `toml:"addr" yaml:"addr"`                       // http service address
+	Name          string            `toml:"name" yaml:"name"`                       // server name shown to users
+	PublicURL     string            `toml:"public_url" yaml:"public_url"`           // url users reach the server with, derived from requests if empty
+	TrustProxy    bool              `toml:"trust_proxy" yaml:"trust_proxy"`         // derive urls from X-Forwarded-Proto and X-Forwarded-Host of a reverse proxy
+	DataDir       string            `toml:"data_dir" yaml:"data_dir"`               // data directory
+	PublicDir     string            `toml:"public_dir" yaml:"public_dir"`           // directory of web files overriding the embedded ones
+	LogLevel      string            `toml:"log_level" yaml:"log_level"`             // trace, debug, info, warn or error
+	LogFormat     string            `toml:"log_format" yaml:"log_format"`           // logfmt or json
+	LogLevels     map[string]string `toml:"log_levels" yaml:"log_levels"`           // levels of subsystems: main, hub, room, client, queue, http, irc, mqtt, telnet and rpc
+	LogSampling   logging.Sampling  `toml:"log_sampling" yaml:"log_sampling"`       // sampling of debug and trace logs
+	AccessLog     string            `toml:"access_log" yaml:"access_log"`           // access log format: common, combined, json or off
+	AccessLogFile string            `toml:"access_log_file" yaml:"access_log_file"` // access log file, stdout if empty
+	Rooms         []string          `toml:"rooms" yaml:"rooms"`                     // rooms created at startup, the first one is the default room
+	Origins       []string          `toml:"origins" yaml:"origins"`                 // origins allowed to connect besides the host
+	Dev           bool              `toml:"dev" yaml:"dev"`                         // allow connections from any origin
+	Features      map[string]bool   `toml:"features" yaml:"features"`               // feature flags of web pages, override the derived ones
+	QR            bool              `toml:"qr" yaml:"qr"`                           // print QR codes of join links at startup
+	TLS           TLSConfig         `toml:"tls" yaml:"tls"`
+	Admin         AdminConfig       `toml:"admin" yaml:"admin"`
+	API           APIConfig         `toml:"api" yaml:"api"`
+	Chat          chat.Options      `toml:"chat" yaml:"chat"`
+	Limits        chat.Limits       `toml:"limits" yaml:"limits"`
+	Discovery     discovery.Options `toml:"discovery" yaml:"discovery"`
+	IRC           irc.Options       `toml:"irc" yaml:"irc"`
+	MQTT          mqtt.Options      `toml:"mqtt" yaml:"mqtt"`
+	Telnet        telnet.Options    `toml:"telnet" yaml:"telnet"`
+	GRPC          rpc.Options       `toml:"grpc" yaml:"grpc"`
+
+	path string // config file path
+}
+
+// TLSConfig of https serving
+type TLSConfig struct {
+	Cert     string `toml:"cert" yaml:"cert"`         // certificate file
+	Key      string `toml:"key" yaml:"key"`           // private key file
+	Auto     bool   `toml:"auto" yaml:"auto"`         // use self-signed certificate for LAN addresses
+	Redirect string `toml:"redirect" yaml:"redirect"` // address redirecting plain http to https
+}
+
+// AdminConfig of the admin listener serving pprof, metrics and admin api
+type AdminConfig struct {
+	Addr     string `toml:"addr" yaml:"addr"`         // admin listener address, disabled if empty
+	Token    string `toml:"token" yaml:"token"`       // bearer token, generated in data directory if no credentials set
+	User     string `toml:"user" yaml:"user"`         // basic auth user
+	Password string `toml:"password" yaml:"password"` // basic auth password
+}
+
+// APIConfig of the REST api at /api
+type APIConfig struct {
+	Tokens []string `toml:"tokens" yaml:"tokens"` // bearer tokens of bots and scripts, api disabled if empty
+}
+
+// DefaultConfig return config with default settings
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:        ":9090",
+		Name:        "私密聊天室",
+		DataDir:     "./data",
+		LogLevel:    "info",
+		LogFormat:   logging.FormatLogfmt,
+		LogSampling: logging.DefaultSampling,
+		AccessLog:   LogCommon,
+		Rooms:       []string{"默认聊天组"},
+		QR:          true,
+		Chat:        chat.DefaultOptions,
+		Limits:      chat.DefaultLimits,
+		Admin:       AdminConfig{Addr: "127.0.0.1:9091"},
+		Discovery:   discovery.DefaultOptions,
+		IRC:         irc.DefaultOptions,
+	}
+}
+
+// LoadConfig load config with command line arguments, the config file is
+// given by -config flag or SPARROW_CONFIG environment variable.
+func LoadConfig(args []string) (*Config, error) {
+	cfg := DefaultConfig()
+	fs := cfg.flagSet()
+
+	// parse flags twice, first to find the config file,
+	// then to override settings in file and environment.
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if cfg.path == "" {
+		cfg.path = os.Getenv(envPrefix + "_CONFIG")
+	}
+	if cfg.path != "" {
+		if err := cfg.loadFile(cfg.path); err != nil {
+			return nil, fmt.Errorf("load config %s: %v", cfg.path, err)
+		}
+	}
+	if err := applyEnv(envPrefix, reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+	fs.Parse(args)
+
+	return cfg, cfg.Validate()
+}
+
+func (cfg *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("sparrow", flag.ContinueOnError)
+	fs.StringVar(&cfg.path, "config", "", "config file, toml or yaml")
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "http service address")
+	fs.StringVar(&cfg.Name, "name", cfg.Name, "server name shown to users")
+	fs.StringVar(&cfg.PublicURL, "public-url", cfg.PublicURL, "url users reach the server with, e.g. https://chat.lan, derived from requests if empty")
+	fs.BoolVar(&cfg.TrustProxy, "trust-proxy", cfg.TrustProxy, "derive urls from X-Forwarded-Proto and X-Forwarded-Host, only behind a reverse proxy setting them")
+	fs.StringVar(&cfg.DataDir, "data", cfg.DataDir, "data directory")
+	fs.StringVar(&cfg.PublicDir, "public", cfg.PublicDir, "directory of web files overriding the embedded ones, e.g. ./public")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: trace, debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: logfmt or json")
+	fs.StringVar(&cfg.AccessLog, "access-log", cfg.AccessLog, "access log format: common, combined, json or off")
+	fs.StringVar(&cfg.AccessLogFile, "access-log-file", cfg.AccessLogFile, "access log file, stdout if empty")
+	fs.Var((*listFlag)(&cfg.Rooms), "rooms", "comma separated rooms created at startup, the first one is the default room")
+	fs.BoolVar(&cfg.QR, "qr", cfg.QR, "print QR codes of join links at startup")
+	fs.StringVar(&cfg.TLS.Cert, "tls-cert", cfg.TLS.Cert, "TLS certificate file, serve https if set with -tls-key")
+	fs.StringVar(&cfg.TLS.Key, "tls-key", cfg.TLS.Key, "TLS private key file")
+	fs.BoolVar(&cfg.TLS.Auto, "tls-auto", cfg.TLS.Auto, "serve https with self-signed certificate for LAN addresses, cached in data directory")
+	fs.StringVar(&cfg.TLS.Redirect, "https-redirect", cfg.TLS.Redirect, "address to redirect plain http requests to https, e.g. :80")
+	fs.StringVar(&cfg.Admin.Addr, "admin-addr", cfg.Admin.Addr, "admin listener address serving pprof, metrics and admin api, disabled if empty")
+	fs.StringVar(&cfg.Admin.Token, "admin-token", cfg.Admin.Token, "bearer token of admin listener, generated in data directory if no credentials set")
+	fs.StringVar(&cfg.IRC.Addr, "irc-addr", cfg.IRC.Addr, "IRC gateway address, e.g. :6667, disabled if empty")
+	fs.StringVar(&cfg.MQTT.Addr, "mqtt-addr", cfg.MQTT.Addr, "MQTT broker address, e.g. :1883, disabled if empty")
+	fs.StringVar(&cfg.Telnet.Addr, "telnet-addr", cfg.Telnet.Addr, "line based TCP chat address, e.g. :2323, disabled if empty")
+	fs.StringVar(&cfg.GRPC.Addr, "grpc-addr", cfg.GRPC.Addr, "gRPC api address, e.g. :9092, needs api tokens, disabled if empty")
+	fs.Var((*listFlag)(&cfg.API.Tokens), "api-tokens", "comma separated bearer tokens of the REST api, disabled if empty")
+	fs.Var((*listFlag)(&cfg.Origins), "origins", "comma separated origins allowed besides the host, e.g. chat.lan,*.example.com")
+	fs.BoolVar(&cfg.Dev, "dev", cfg.Dev, "dev mode, allow websocket connections from any origin")
+	fs.BoolVar(&cfg.Discovery.MDNS, "mdns", cfg.Discovery.MDNS, "advertise server on the LAN with mDNS")
+	fs.BoolVar(&cfg.Discovery.Beacon, "beacon", cfg.Discovery.Beacon, "advertise server on the LAN with UDP broadcast beacons")
+	fs.Float64Var(&cfg.Limits.MessageRate, "msg-rate", cfg.Limits.MessageRate, "messages per second of each ip, 0 for unlimited")
+	fs.IntVar(&cfg.Limits.MessageBurst, "msg-burst", cfg.Limits.MessageBurst, "messages allowed in a burst")
+	fs.IntVar(&cfg.Limits.RoomsPerHour, "rooms-per-hour", cfg.Limits.RoomsPerHour, "room creations per hour of each ip, 0 for unlimited")
+	fs.IntVar(&cfg.Limits.MaxConnsPerIP, "max-conns-ip", cfg.Limits.MaxConnsPerIP, "concurrent connections of each ip, 0 for unlimited")
+	return fs
+}
+
+func (cfg *Config) loadFile(path string) error {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		// unknown keys are mistakes, like yaml is strict
+		md, err := toml.Decode(string(bs), cfg)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return fmt.Errorf("unknown config keys: %s", strings.Join(keys, ", "))
+		}
+		return nil
+	case ".yaml", ".yml":
+		return yaml.UnmarshalStrict(bs, cfg)
+	default:
+		return errors.New("unknown config format, use .toml or .yaml")
+	}
+}
+
+// Validate check settings
+func (cfg *Config) Validate() error {
+	switch {
+	case cfg.Addr == "":
+		return errors.New("addr is required")
+	case cfg.DataDir == "":
+		return errors.New("data_dir is required")
+	case len(cfg.Rooms) == 0:
+		return errors.New("at least one room is required")
+	case (cfg.TLS.Cert == "") != (cfg.TLS.Key == ""):
+		return errors.New("tls cert and key must be set together")
+	case (cfg.Admin.User == "") != (cfg.Admin.Password == ""):
+		return errors.New("admin user and password must be set together")
+	case cfg.Chat.WriteWait <= 0 || cfg.Chat.PongWait <= 0:
+		return errors.New("chat write_wait and pong_wait must be positive")
+	case cfg.Chat.MaxMessageSize <= 0 || cfg.Chat.MaxQueueSize <= 0:
+		return errors.New("chat max_message_size and max_queue_size must be positive")
+	case cfg.Chat.HistorySize < 0:
+		return errors.New("chat history_size must not be negative")
+	}
+
+	// each listener needs its own address
+	listeners := []struct{ name, addr string }{
+		{"addr", cfg.Addr},
+		{"admin addr", cfg.Admin.Addr},
+		{"irc addr", cfg.IRC.Addr},
+		{"mqtt addr", cfg.MQTT.Addr},
+		{"telnet addr", cfg.Telnet.Addr},
+		{"grpc addr", cfg.GRPC.Addr},
+	}
+	for i, l := range listeners {
+		for _, other := range listeners[:i] {
+			if l.addr != "" && l.addr == other.addr {
+				return fmt.Errorf("%s must differ from %s", l.name, other.name)
+			}
+		}
+	}
+	if cfg.Discovery.Beacon && (cfg.Discovery.BeaconPort <= 0 || cfg.Discovery.BeaconPort > 65535) {
+		return fmt.Errorf("invalid discovery beacon_port %d", cfg.Discovery.BeaconPort)
+	}
+	if cfg.PublicURL != "" {
+		u, err := url.Parse(cfg.PublicURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid public_url %q, want http(s)://host[:port][/path]", cfg.PublicURL)
+		}
+	}
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		return fmt.Errorf("log_level: %v", err)
+	}
+	for name, level := range cfg.LogLevels {
+		if _, err := logging.ParseLevel(level); err != nil {
+			return fmt.Errorf("log_levels.%s: %v", name, err)
+		}
+	}
+	if cfg.LogFormat != logging.FormatLogfmt && cfg.LogFormat != logging.FormatJSON {
+		return fmt.Errorf("unknown log_format %q", cfg.LogFormat)
+	}
+	switch cfg.AccessLog {
+	case LogCommon, LogCombined, LogJSON, LogOff:
+	default:
+		return fmt.Errorf("unknown access_log format %q", cfg.AccessLog)
+	}
+	for _, room := range cfg.Rooms {
+		if chat.Slugify(room) == "" {
+			return fmt.Errorf("invalid room name %q", room)
+		}
+	}
+
+	l := cfg.Limits
+	if l.MessageRate < 0 || l.MessageBurst < 0 || l.RoomsPerHour < 0 ||
+		l.MaxConnsPerIP < 0 || l.MuteAfter < 0 ||
+		l.MuteDuration < 0 || l.DisconnectAfter < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// Logging return logging options of config
+func (cfg *Config) Logging() logging.Options {
+	return logging.Options{
+		Format:   cfg.LogFormat,
+		Level:    cfg.LogLevel,
+		Levels:   cfg.LogLevels,
+		Sampling: cfg.LogSampling,
+	}
+}
+
+// listFlag is a comma separated string list flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = splitList(s)
+	return nil
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv override struct fields with environment variables, variable
+// names are the prefix and upper cased toml keys joined by underscore.
+func applyEnv(prefix string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("toml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnv(name, fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(fv, s); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err == nil {
+			v.SetInt(int64(d))
+		}
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return errors.New("unsupported list type")
+		}
+		v.Set(reflect.ValueOf(splitList(s)))
+	case reflect.Map:
+		// key=value pairs, e.g. client=debug,hub=trace or voice=true,
+		// values are parsed like scalars of their type
+		if v.Type().Key().Kind() != reflect.String {
+			return errors.New("unsupported map type")
+		}
+		m := reflect.MakeMap(v.Type())
+		for _, kv := range splitList(s) {
+			i := strings.Index(kv, "=")
+			if i <= 0 {
+				return fmt.Errorf("invalid pair %q, want key=value", kv)
+			}
+			key := strings.TrimSpace(kv[:i])
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(elem, strings.TrimSpace(kv[i+1:])); err != nil {
+				return fmt.Errorf("invalid value of %q: %v", key, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
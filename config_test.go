@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "sparrow.toml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`
+addr = ":8080"
+log_level = "warn"
+rooms = ["大厅", "lobby"]
+
+[chat]
+pong_wait = "30s"
+
+[limits]
+message_rate = 2.5
+`), 0644))
+
+	// file over defaults, env over file, flags over env
+	t.Setenv("SPARROW_LIMITS_MESSAGE_BURST", "3")
+	t.Setenv("SPARROW_ORIGINS", "chat.lan, *.example.com")
+	t.Setenv("SPARROW_LOG_LEVEL", "info")
+	t.Setenv("SPARROW_LOG_LEVELS", "client=debug, hub=trace")
+	t.Setenv("SPARROW_LOG_SAMPLING_INITIAL", "10")
+	cfg, err := LoadConfig([]string{"-config", file, "-log-level", "error"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, ":8080", cfg.Addr)
+	assert.Equal(t, "./data", cfg.DataDir)
+	assert.Equal(t, []string{"大厅", "lobby"}, cfg.Rooms)
+	assert.Equal(t, []string{"chat.lan", "*.example.com"}, cfg.Origins)
+	assert.Equal(t, "error", cfg.LogLevel)
+	assert.Equal(t, map[string]string{"client": "debug", "hub": "trace"}, cfg.LogLevels)
+	assert.Equal(t, 10, cfg.LogSampling.Initial)
+	assert.Equal(t, 30*time.Second, cfg.Chat.PongWait)
+	assert.Equal(t, 10*time.Second, cfg.Chat.WriteWait)
+	assert.Equal(t, 2.5, cfg.Limits.MessageRate)
+	assert.Equal(t, 3, cfg.Limits.MessageBurst)
+}
+
+func TestLoadConfigEnvMap(t *testing.T) {
+	t.Setenv("SPARROW_FEATURES", "voice=true, tls=false")
+	cfg, err := LoadConfig(nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]bool{"voice": true, "tls": false}, cfg.Features)
+	}
+
+	t.Setenv("SPARROW_FEATURES", "voice=loud")
+	_, err = LoadConfig(nil)
+	assert.Error(t, err)
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sparrow.yaml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("addr: \":7070\"\nlimits:\n  mute_duration: 5m\n"), 0644))
+
+	t.Setenv("SPARROW_CONFIG", file)
+	cfg, err := LoadConfig(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ":7070", cfg.Addr)
+	assert.Equal(t, 5*time.Minute, cfg.Limits.MuteDuration)
+}
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "sparrow.toml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("adr = \":7070\"\n[limits]\nmsg_rate = 1.0\n"), 0644))
+	_, err := LoadConfig([]string{"-config", file})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "adr")
+		assert.Contains(t, err.Error(), "limits.msg_rate")
+	}
+
+	file = filepath.Join(dir, "sparrow.yaml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("adr: \":7070\"\n"), 0644))
+	_, err = LoadConfig([]string{"-config", file})
+	assert.Error(t, err)
+}
+
+func TestSampleConfig(t *testing.T) {
+	cfg, err := LoadConfig([]string{"-config", "deploy/conf/sparrow.toml"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, DefaultConfig().Limits, cfg.Limits)
+	assert.Equal(t, DefaultConfig().Discovery, cfg.Discovery)
+}
+
+func TestConfigValidate(t *testing.T) {
+	_, err := LoadConfig([]string{"-log-level", "verbose"})
+	assert.Error(t, err)
+
+	_, err = LoadConfig([]string{"-tls-cert", "cert.pem"})
+	assert.Error(t, err)
+
+	_, err = LoadConfig([]string{"-admin-addr", ":9090"})
+	assert.Error(t, err)
+	_, err = LoadConfig([]string{"-irc-addr", "127.0.0.1:9091"})
+	assert.Error(t, err)
+	_, err = LoadConfig([]string{"-irc-addr", ":6667", "-mqtt-addr", ":6667"})
+	assert.Error(t, err)
+	_, err = LoadConfig([]string{"-mqtt-addr", ":1883", "-telnet-addr", ":1883"})
+	assert.Error(t, err)
+	_, err = LoadConfig([]string{"-grpc-addr", ":9090"})
+	assert.EqualError(t, err, "grpc addr must differ from addr")
+
+	t.Setenv("SPARROW_ADMIN_USER", "admin")
+	_, err = LoadConfig(nil)
+	assert.Error(t, err)
+	t.Setenv("SPARROW_ADMIN_USER", "")
+
+	t.Setenv("SPARROW_CHAT_MAX_QUEUE_SIZE", "many")
+	_, err = LoadConfig(nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"./discovery"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// advertise local server on the LAN, so users don't have to type addresses
+func advertise(cfg *Config, rooms func() int) *discovery.Advertiser {
+	if !cfg.Discovery.MDNS && !cfg.Discovery.Beacon {
+		return nil
+	}
+
+	host, p, err := net.SplitHostPort(cfg.Addr)
+	port, _ := strconv.Atoi(p)
+	if err != nil || port == 0 {
+		mainLog.Warn("can't advertise server, port is required", "addr", cfg.Addr)
+		return nil
+	}
+	ips := GetLocalIPAddr()
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		// listen on the address only
+		ips = []string{host}
+	}
+
+	ad, err := discovery.Advertise(discovery.Info{
+		Name:  cfg.Name,
+		Port:  port,
+		TLS:   cfg.TLS.Auto || cfg.TLS.Cert != "",
+		IPs:   ips,
+		Rooms: rooms,
+	}, cfg.Discovery)
+	if err != nil {
+		mainLog.Error("advertise server on the LAN failed", "err", err)
+		return nil
+	}
+	mainLog.Info("server advertised on the LAN", "service", discovery.Service)
+	return ad
+}
+
+// discoverCmd is the `sparrow discover` subcommand, list servers on the LAN
+func discoverCmd(args []string) int {
+	opts := discovery.DefaultOptions
+	fs := flag.NewFlagSet("sparrow discover", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", opts.Interval+time.Second, "how long to wait for servers")
+	asJSON := fs.Bool("json", false, "print servers as json")
+	fs.BoolVar(&opts.MDNS, "mdns", opts.MDNS, "find servers with mDNS")
+	fs.BoolVar(&opts.Beacon, "beacon", opts.Beacon, "find servers with UDP broadcast beacons")
+	fs.IntVar(&opts.BeaconPort, "beacon-port", opts.BeaconPort, "UDP port of beacons")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	// beacons are sent every few seconds, wait for one round at least
+	if opts.Beacon && *timeout < opts.Interval {
+		fmt.Fprintf(os.Stderr, "timeout is shorter than beacon interval %s, servers may be missed.\n", opts.Interval)
+	}
+
+	servers, err := discovery.Discover(*timeout, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "discover failed:", err)
+		return 1
+	}
+
+	if *asJSON {
+		out := make([]map[string]interface{}, 0, len(servers))
+		for _, s := range servers {
+			out = append(out, map[string]interface{}{
+				"name": s.Name, "url": s.URL(), "rooms": s.Rooms, "via": s.Via,
+			})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+		return 0
+	}
+
+	if len(servers) == 0 {
+		fmt.Println("no sparrow server found on the LAN.")
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tURL\tROOMS\tVIA")
+	for _, s := range servers {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", s.Name, s.URL(), s.Rooms, s.Via)
+	}
+	w.Flush()
+	return 0
+}
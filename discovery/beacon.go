@@ -0,0 +1,142 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// beaconMessage is broadcast by servers periodically
+type beaconMessage struct {
+	Service string `json:"service"`
+	Name    string `json:"name"`
+	Port    int    `json:"port"`
+	TLS     bool   `json:"tls"`
+	Rooms   int    `json:"rooms"`
+}
+
+// beacon broadcasts beacon messages to the LAN
+type beacon struct {
+	info     Info
+	port     int
+	interval time.Duration
+	conn     *net.UDPConn
+	addrs    func() []net.IP // addresses beacons are sent to
+	quit     chan struct{}
+}
+
+func newBeacon(info Info, opts Options) (*beacon, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultOptions.Interval
+	}
+	return &beacon{
+		info:     info,
+		port:     opts.BeaconPort,
+		interval: interval,
+		conn:     conn,
+		addrs:    broadcastAddrs,
+		quit:     make(chan struct{}),
+	}, nil
+}
+
+func (b *beacon) run() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		b.send()
+		select {
+		case <-ticker.C:
+		case <-b.quit:
+			return
+		}
+	}
+}
+
+// send beacon to broadcast address of each interface, errors are ignored
+// since some interfaces don't support broadcast.
+func (b *beacon) send() {
+	data, _ := json.Marshal(&beaconMessage{
+		Service: Service,
+		Name:    b.info.Name,
+		Port:    b.info.Port,
+		TLS:     b.info.TLS,
+		Rooms:   b.info.rooms(),
+	})
+	for _, ip := range b.addrs() {
+		b.conn.WriteToUDP(data, &net.UDPAddr{IP: ip, Port: b.port})
+	}
+}
+
+// Close stop broadcasting
+func (b *beacon) Close() error {
+	close(b.quit)
+	return b.conn.Close()
+}
+
+// broadcastAddrs return the limited broadcast address and directed
+// broadcast addresses of up interfaces.
+func broadcastAddrs() []net.IP {
+	ips := []net.IP{net.IPv4bcast}
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil || ipNet.IP.IsLoopback() {
+				continue
+			}
+			ip, mask := ipNet.IP.To4(), net.IP(ipNet.Mask).To4()
+			if mask == nil {
+				continue
+			}
+			bcast := make(net.IP, 4)
+			for i := range bcast {
+				bcast[i] = ip[i] | ^mask[i]
+			}
+			ips = append(ips, bcast)
+		}
+	}
+	return ips
+}
+
+// listenBeacons receive beacons on port until timeout
+func listenBeacons(port int, timeout time.Duration, found func(*Server)) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil
+			}
+			return err
+		}
+
+		var msg beaconMessage
+		if json.Unmarshal(buf[:n], &msg) != nil || msg.Service != Service || msg.Port == 0 {
+			continue
+		}
+		found(&Server{
+			Name:  msg.Name,
+			Host:  from.IP.String(),
+			Port:  msg.Port,
+			TLS:   msg.TLS,
+			Rooms: msg.Rooms,
+			Via:   "beacon",
+		})
+	}
+}
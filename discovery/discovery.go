@@ -0,0 +1,179 @@
+// Package discovery advertises sparrow servers on the LAN and finds them,
+// with mDNS/DNS-SD service _sparrow._tcp and UDP broadcast beacons.
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Service type of sparrow servers in DNS-SD
+const Service = "_sparrow._tcp"
+
+// Options of LAN discovery
+type Options struct {
+	MDNS       bool          `toml:"mdns" yaml:"mdns"`               // advertise with mDNS
+	Beacon     bool          `toml:"beacon" yaml:"beacon"`           // broadcast UDP beacons
+	BeaconPort int           `toml:"beacon_port" yaml:"beacon_port"` // UDP port beacons are sent to
+	Interval   time.Duration `toml:"interval" yaml:"interval"`       // how often beacons are sent
+}
+
+// DefaultOptions of LAN discovery
+var DefaultOptions = Options{
+	MDNS:       true,
+	Beacon:     true,
+	BeaconPort: 9190,
+	Interval:   5 * time.Second,
+}
+
+// Info of local server to advertise
+type Info struct {
+	Name  string     // server name
+	Port  int        // http port
+	TLS   bool       // serve https
+	IPs   []string   // LAN addresses
+	Rooms func() int // current room count
+}
+
+func (info *Info) rooms() int {
+	if info.Rooms == nil {
+		return 0
+	}
+	return info.Rooms()
+}
+
+// Server found on the LAN
+type Server struct {
+	Name  string `json:"name"`
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+	TLS   bool   `json:"tls"`
+	Rooms int    `json:"rooms"`
+	Via   string `json:"via"` // mdns or beacon
+}
+
+// URL of the server
+func (s *Server) URL() string {
+	scheme := "http"
+	if s.TLS {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+}
+
+// Advertiser advertises local server until it's closed
+type Advertiser struct {
+	closers []func() error
+}
+
+// Advertise start advertising local server with enabled methods
+func Advertise(info Info, opts Options) (*Advertiser, error) {
+	a := &Advertiser{}
+	if opts.MDNS {
+		srv, err := advertiseMDNS(info)
+		if err != nil {
+			return nil, fmt.Errorf("mdns: %v", err)
+		}
+		a.closers = append(a.closers, srv.Shutdown)
+	}
+	if opts.Beacon {
+		b, err := newBeacon(info, opts)
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("beacon: %v", err)
+		}
+		go b.run()
+		a.closers = append(a.closers, b.Close)
+	}
+	return a, nil
+}
+
+// Close stop advertising
+func (a *Advertiser) Close() error {
+	var err error
+	for _, c := range a.closers {
+		if e := c(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Discover find servers on the LAN within timeout, with mDNS queries
+// and listening to beacons at the same time.
+func Discover(timeout time.Duration, opts Options) ([]*Server, error) {
+	var (
+		wg    sync.WaitGroup
+		lck   sync.Mutex
+		found = map[string]*Server{}
+		errs  []error
+	)
+	add := func(s *Server) {
+		lck.Lock()
+		defer lck.Unlock()
+		key := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+		if old, ok := found[key]; ok {
+			// keep the first found, update rooms with the latest
+			old.Rooms = s.Rooms
+			return
+		}
+		found[key] = s
+	}
+	fail := func(err error) {
+		lck.Lock()
+		errs = append(errs, err)
+		lck.Unlock()
+	}
+
+	if opts.MDNS {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := queryMDNS(timeout, add); err != nil {
+				fail(fmt.Errorf("mdns: %v", err))
+			}
+		}()
+	}
+	if opts.Beacon {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := listenBeacons(opts.BeaconPort, timeout, add); err != nil {
+				fail(fmt.Errorf("beacon: %v", err))
+			}
+		}()
+	}
+	wg.Wait()
+
+	servers := make([]*Server, 0, len(found))
+	for _, s := range found {
+		servers = append(servers, s)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Name != servers[j].Name {
+			return servers[i].Name < servers[j].Name
+		}
+		return servers[i].URL() < servers[j].URL()
+	})
+
+	// failure of one method is fine if the other works
+	if len(servers) == 0 && len(errs) > 0 && len(errs) == countEnabled(opts) {
+		return nil, errs[0]
+	}
+	return servers, nil
+}
+
+func countEnabled(opts Options) int {
+	n := 0
+	if opts.MDNS {
+		n++
+	}
+	if opts.Beacon {
+		n++
+	}
+	return n
+}
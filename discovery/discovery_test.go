@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/mdns"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeacon(t *testing.T) {
+	// find a free udp port for the test
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	port := l.LocalAddr().(*net.UDPAddr).Port
+	l.Close()
+
+	rooms := 3
+	info := Info{Name: "lan chat", Port: 9090, Rooms: func() int { return rooms }}
+	b, err := newBeacon(info, Options{BeaconPort: port, Interval: 20 * time.Millisecond})
+	assert.NoError(t, err)
+	defer b.Close()
+
+	// broadcast may be unavailable in sandboxes, send to loopback
+	b.addrs = func() []net.IP { return []net.IP{net.IPv4(127, 0, 0, 1)} }
+	go b.run()
+
+	var found []*Server
+	assert.NoError(t, listenBeacons(port, 150*time.Millisecond, func(s *Server) {
+		found = append(found, s)
+	}))
+	if assert.NotEmpty(t, found) {
+		s := found[0]
+		assert.Equal(t, "lan chat", s.Name)
+		assert.Equal(t, 3, s.Rooms)
+		assert.Equal(t, "http://127.0.0.1:9090", s.URL())
+		assert.Equal(t, "beacon", s.Via)
+	}
+}
+
+func TestFromEntry(t *testing.T) {
+	s := fromEntry(&mdns.ServiceEntry{
+		Name:       "office._sparrow._tcp.local.",
+		AddrV4:     net.IPv4(192, 168, 1, 10),
+		Port:       9443,
+		InfoFields: []string{"rooms=5", "tls=true", "bogus"},
+	})
+	assert.Equal(t, &Server{Name: "office", Host: "192.168.1.10", Port: 9443, TLS: true, Rooms: 5, Via: "mdns"}, s)
+	assert.Equal(t, "https://192.168.1.10:9443", s.URL())
+
+	assert.Nil(t, fromEntry(&mdns.ServiceEntry{Name: "no address"}))
+
+	// non-ascii txt is escaped by dns library
+	s = fromEntry(&mdns.ServiceEntry{
+		AddrV4:     net.IPv4(192, 168, 1, 10),
+		Port:       9090,
+		InfoFields: []string{`name=\231\167\129\232\129\138 \"a\\b\"`},
+	})
+	assert.Equal(t, `私聊 "a\b"`, s.Name)
+}
+
+func TestZoneRecords(t *testing.T) {
+	rooms := 1
+	info := Info{Name: "lan", Port: 9090, IPs: []string{"192.168.1.10"}, Rooms: func() int { return rooms }}
+	svc, err := mdns.NewMDNSService("lan", Service, "", "host.local.", 9090, []net.IP{net.IPv4(192, 168, 1, 10)}, nil)
+	assert.NoError(t, err)
+
+	z := &zone{svc: svc, info: info}
+	q := dns.Question{Name: "lan." + Service + ".local.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET}
+	assert.NotEmpty(t, z.Records(q))
+	assert.Contains(t, svc.TXT, "rooms=1")
+
+	// room count is updated on each query
+	rooms = 4
+	z.Records(q)
+	assert.Contains(t, svc.TXT, "rooms=4")
+}
@@ -0,0 +1,158 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/mdns"
+	"github.com/miekg/dns"
+)
+
+// quiet logger, mdns logs every malformed packet on the LAN
+var mdnsLogger = log.New(ioutil.Discard, "", 0)
+
+// zone answers mDNS queries with TXT records of current room count
+type zone struct {
+	lck  sync.Mutex
+	svc  *mdns.MDNSService
+	info Info
+}
+
+// Records implements mdns.Zone
+func (z *zone) Records(q dns.Question) []dns.RR {
+	z.lck.Lock()
+	defer z.lck.Unlock()
+	z.svc.TXT = txtRecords(&z.info)
+	return z.svc.Records(q)
+}
+
+func txtRecords(info *Info) []string {
+	return []string{
+		"name=" + info.Name,
+		"rooms=" + strconv.Itoa(info.rooms()),
+		"tls=" + strconv.FormatBool(info.TLS),
+	}
+}
+
+func advertiseMDNS(info Info) (*mdns.Server, error) {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "sparrow"
+	}
+	// mdns wants a fqdn host, trim domain of the hostname
+	if i := strings.Index(host, "."); i > 0 {
+		host = host[:i]
+	}
+
+	var ips []net.IP
+	for _, s := range info.IPs {
+		if ip := net.ParseIP(s); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	// dots split dns labels, so they are not allowed in instance name
+	instance := strings.Replace(info.Name, ".", "-", -1)
+	if instance == "" {
+		instance = host
+	}
+
+	svc, err := mdns.NewMDNSService(instance, Service, "", host+".local.", info.Port, ips, txtRecords(&info))
+	if err != nil {
+		return nil, err
+	}
+	return mdns.NewServer(&mdns.Config{
+		Zone:   &zone{svc: svc, info: info},
+		Logger: mdnsLogger,
+	})
+}
+
+func queryMDNS(timeout time.Duration, found func(*Server)) error {
+	entries := make(chan *mdns.ServiceEntry, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range entries {
+			if s := fromEntry(e); s != nil {
+				found(s)
+			}
+		}
+	}()
+
+	err := mdns.Query(&mdns.QueryParam{
+		Service:     Service,
+		Domain:      "local",
+		Timeout:     timeout,
+		Entries:     entries,
+		DisableIPv6: true,
+		Logger:      mdnsLogger,
+	})
+	close(entries)
+	<-done
+	return err
+}
+
+func fromEntry(e *mdns.ServiceEntry) *Server {
+	if e.AddrV4 == nil || e.Port == 0 {
+		return nil
+	}
+	s := &Server{
+		Name: strings.TrimSuffix(e.Name, "."+Service+".local."),
+		Host: e.AddrV4.String(),
+		Port: e.Port,
+		Via:  "mdns",
+	}
+	for _, field := range e.InfoFields {
+		kv := strings.SplitN(unescape(field), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "name":
+			s.Name = kv[1]
+		case "rooms":
+			s.Rooms, _ = strconv.Atoi(kv[1])
+		case "tls":
+			s.TLS, _ = strconv.ParseBool(kv[1])
+		}
+	}
+	return s
+}
+
+// unescape TXT strings escaped in zone file format, e.g. \229 or \"
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			buf = append(buf, s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigits(s[i+1:i+4]) {
+			n, _ := strconv.Atoi(s[i+1 : i+4])
+			buf = append(buf, byte(n))
+			i += 3
+			continue
+		}
+		buf = append(buf, s[i+1])
+		i++
+	}
+	return string(buf)
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
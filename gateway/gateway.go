@@ -0,0 +1,141 @@
+// Package gateway is what the TCP gateways of the room hub share, the
+// accept loop of their listeners, and the queue of hub messages read from
+// a connection of a protocol other than websocket.
+package gateway
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+
+	"../chat"
+)
+
+var (
+	// ErrServerClosed returned by Serve after Close
+	ErrServerClosed = errors.New("gateway: server closed")
+
+	// ErrTooLong message data is longer than max message size of hub
+	ErrTooLong = chat.ErrTooLong
+)
+
+// Listener accepts TCP connections and serves each in its own routine
+type Listener struct {
+	name  string // what is started, in logs
+	log   *slog.Logger
+	serve func(nc net.Conn)
+
+	lck    sync.Mutex
+	ln     net.Listener
+	closed bool
+}
+
+// NewListener create listener serving connections with serve
+func NewListener(name string, log *slog.Logger, serve func(nc net.Conn)) *Listener {
+	return &Listener{name: name, log: log, serve: serve}
+}
+
+// ListenAndServe listen on TCP addr and serve connections
+func (l *Listener) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Serve(ln)
+}
+
+// Serve connections accepted by ln until Close
+func (l *Listener) Serve(ln net.Listener) error {
+	l.lck.Lock()
+	if l.closed {
+		l.lck.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	l.ln = ln
+	l.lck.Unlock()
+
+	l.log.Info(l.name+" started", "addr", ln.Addr().String())
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			l.lck.Lock()
+			closed := l.closed
+			l.lck.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go l.serve(nc)
+	}
+}
+
+// Close stop accepting connections, connected clients are closed with hub
+func (l *Listener) Close() error {
+	l.lck.Lock()
+	defer l.lck.Unlock()
+	l.closed = true
+	if l.ln != nil {
+		return l.ln.Close()
+	}
+	return nil
+}
+
+// Queue of hub messages read from a connection, one command or packet of
+// the protocol may turn into none or several messages.
+type Queue struct {
+	read    func() error // read next command or packet, and add its messages
+	max     int64        // max message size, 0 for unlimited
+	pending []*chat.Message
+}
+
+// NewQueue create queue filled by read, messages with data longer than max
+// bytes are rejected like websocket clients are.
+func NewQueue(read func() error, max int64) *Queue {
+	return &Queue{read: read, max: max}
+}
+
+// Add message for hub, ErrTooLong if data is longer than max message size
+func (q *Queue) Add(typ, from, room, data string) error {
+	if q.max > 0 && int64(len(data)) > q.max {
+		return ErrTooLong
+	}
+	q.pending = append(q.pending, &chat.Message{
+		Type: typ,
+		From: from,
+		Room: room,
+		Data: data,
+	})
+	return nil
+}
+
+// ReadMessage read until a message is added, io.EOF if peer has gone
+func (q *Queue) ReadMessage() (*chat.Message, error) {
+	for len(q.pending) == 0 {
+		if err := q.read(); err != nil {
+			if Closed(err) {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+	}
+	msg := q.pending[0]
+	q.pending = q.pending[1:]
+	return msg, nil
+}
+
+// Closed check if error of network connection means peer has gone,
+// or it has been closed.
+func Closed(err error) bool {
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
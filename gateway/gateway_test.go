@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"../chat"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListener(t *testing.T) {
+	served := make(chan net.Conn, 1)
+	l := NewListener("test listener", slog.Default(), func(nc net.Conn) {
+		served <- nc
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Serve(ln)
+	}()
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer nc.Close()
+	(<-served).Close()
+
+	assert.NoError(t, l.Close())
+	assert.Equal(t, ErrServerClosed, <-done)
+	assert.Equal(t, ErrServerClosed, l.Serve(ln))
+}
+
+func TestQueue(t *testing.T) {
+	var q *Queue
+	inputs := []error{nil, nil, net.ErrClosed}
+	q = NewQueue(func() error {
+		err := inputs[0]
+		inputs = inputs[1:]
+		if err == nil && len(inputs) == 2 {
+			// first input has two messages, the second none
+			q.Add(chat.T_JOIN, "alice", "general", "")
+			q.Add(chat.T_MESSAGE, "alice", "general", "hi")
+		}
+		return err
+	}, 8)
+
+	msg, err := q.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, chat.T_JOIN, msg.Type)
+	msg, err = q.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", msg.Data)
+	_, err = q.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+
+	bad := errors.New("bad packet")
+	q = NewQueue(func() error { return bad }, 8)
+	_, err = q.ReadMessage()
+	assert.Equal(t, bad, err)
+	assert.Equal(t, ErrTooLong, q.Add(chat.T_MESSAGE, "alice", "general", "too long message"))
+	assert.NoError(t, NewQueue(nil, 0).Add(chat.T_MESSAGE, "alice", "general", "too long message"))
+}
@@ -0,0 +1,54 @@
+// Package gatewaytest dials gateways in tests, and talks to line based ones.
+package gatewaytest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Client of a gateway, lines are sent ending with EOL
+type Client struct {
+	T    *testing.T
+	Conn net.Conn
+	R    *bufio.Reader
+	EOL  string
+}
+
+// Dial connect gateway listening on addr, test fails if it can't
+func Dial(t *testing.T, addr, eol string) *Client {
+	nc, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return &Client{T: t, Conn: nc, R: bufio.NewReader(nc), EOL: eol}
+}
+
+// Send line
+func (c *Client) Send(line string) {
+	_, err := c.Conn.Write([]byte(line + c.EOL))
+	assert.NoError(c.T, err)
+}
+
+// Expect read lines until one contains s, and return it
+func (c *Client) Expect(s string) string {
+	c.Conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		line, err := c.R.ReadString('\n')
+		if !assert.NoError(c.T, err, "waiting for %q", s) {
+			return ""
+		}
+		if strings.Contains(line, s) {
+			return strings.TrimRight(line, "\r\n")
+		}
+	}
+}
+
+// Close connection
+func (c *Client) Close() error {
+	return c.Conn.Close()
+}
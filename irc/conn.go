@@ -0,0 +1,523 @@
+package irc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../chat"
+	"../gateway"
+)
+
+// longest line accepted from clients, longer ones close the connection
+const maxLine = 4096
+
+// errQuit client sent QUIT
+var errQuit = errors.New("quit")
+
+// conn is the chat.Conn of an IRC client, commands are translated into
+// messages of the hub, and messages from the hub into IRC lines. Commands
+// without a hub message, e.g. PING and NAMES, are answered directly.
+type conn struct {
+	srv  *Server
+	nc   net.Conn
+	opts chat.Options
+	in   *bufio.Scanner
+
+	wlck sync.Mutex // guards writes
+	w    *bufio.Writer
+
+	lck      sync.Mutex
+	nick     string
+	user     string
+	channels map[string]string // joined room id -> channel
+
+	*gateway.Queue // messages of commands not read yet
+}
+
+func newConn(srv *Server, nc net.Conn) *conn {
+	in := bufio.NewScanner(nc)
+	in.Buffer(make([]byte, 512), maxLine)
+	c := &conn{
+		srv:      srv,
+		nc:       nc,
+		opts:     srv.hub.Options(),
+		in:       in,
+		w:        bufio.NewWriter(nc),
+		channels: make(map[string]string),
+	}
+	c.Queue = gateway.NewQueue(c.next, c.opts.MaxMessageSize)
+	return c
+}
+
+func (c *conn) getNick() string {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	return c.nick
+}
+
+// prefix of messages from this client
+func (c *conn) prefix() string {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	return c.nick + "!" + c.user + "@" + c.srv.name
+}
+
+// channel return channel of joined room
+func (c *conn) channel(roomID string) (string, bool) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	ch, ok := c.channels[roomID]
+	return ch, ok
+}
+
+// roomOf return id of joined room by channel name
+func (c *conn) roomOf(channel string) (string, bool) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	for id, ch := range c.channels {
+		if strings.EqualFold(ch, channel) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// readLine read next line, peers silent for PongWait are gone
+func (c *conn) readLine() (string, error) {
+	c.nc.SetReadDeadline(time.Now().Add(c.opts.PongWait))
+	if !c.in.Scan() {
+		if err := c.in.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return c.in.Text(), nil
+}
+
+// send write a line, the last parameter is always sent as trailing
+func (c *conn) send(prefix, cmd string, params ...string) error {
+	var sb strings.Builder
+	if prefix != "" {
+		sb.WriteString(":" + prefix + " ")
+	}
+	sb.WriteString(cmd)
+	for i, p := range params {
+		// line breaks would inject commands
+		p = strings.NewReplacer("\r", " ", "\n", " ").Replace(p)
+		if i == len(params)-1 {
+			sb.WriteString(" :" + p)
+		} else {
+			sb.WriteString(" " + p)
+		}
+	}
+	sb.WriteString("\r\n")
+
+	c.wlck.Lock()
+	defer c.wlck.Unlock()
+	c.nc.SetWriteDeadline(time.Now().Add(c.opts.WriteWait))
+	if _, err := c.w.WriteString(sb.String()); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// reply send numeric reply to this client
+func (c *conn) reply(code string, params ...string) error {
+	nick := c.getNick()
+	if nick == "" {
+		nick = "*"
+	}
+	return c.send(c.srv.name, code, append([]string{nick}, params...)...)
+}
+
+// register handle commands until client has sent NICK and USER
+func (c *conn) register() error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		cmd, params := parse(line)
+		switch cmd {
+		case "":
+		case "NICK", "PING", "QUIT", "PASS", "CAP":
+			if err := c.command(cmd, params); err != nil {
+				return err
+			}
+		case "USER":
+			if len(params) < 4 {
+				c.reply("461", cmd, "Not enough parameters")
+				break
+			}
+			c.lck.Lock()
+			c.user = nickOf(params[0])
+			c.lck.Unlock()
+		default:
+			c.reply("451", "You have not registered")
+		}
+
+		c.lck.Lock()
+		done := c.nick != "" && c.user != ""
+		c.lck.Unlock()
+		if done {
+			return c.welcome()
+		}
+	}
+}
+
+func (c *conn) welcome() error {
+	nick := c.getNick()
+	c.reply("001", "Welcome to sparrow, "+c.prefix())
+	c.reply("002", "Your host is "+c.srv.name)
+	c.reply("003", "This server bridges sparrow chat rooms")
+	c.reply("004", c.srv.name, "sparrow", "o", "nt")
+	c.reply("005", "CHANTYPES=#", "CASEMAPPING=ascii", "NICKLEN=64", "are supported by this server")
+	return c.reply("422", "MOTD File is missing, join one of the channels in LIST, "+nick)
+}
+
+// next read and handle next command, hub messages of it are queued
+func (c *conn) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	cmd, params := parse(line)
+	if cmd == "" {
+		return nil
+	}
+	if err := c.command(cmd, params); err == errQuit {
+		return io.EOF
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// queue message for hub, sent by this client
+func (c *conn) queue(typ, room, data string) {
+	if err := c.Add(typ, c.getNick(), room, data); err != nil {
+		c.reply("417", fmt.Sprintf("Input line was too long, max %d bytes", c.opts.MaxMessageSize))
+	}
+}
+
+// command handle a command of client
+func (c *conn) command(cmd string, params []string) error {
+	need := map[string]int{"NICK": 1, "JOIN": 1, "PART": 1, "PRIVMSG": 1, "TOPIC": 1}
+	if n, ok := need[cmd]; ok && len(params) < n {
+		if cmd == "NICK" {
+			return c.reply("431", "No nickname given")
+		}
+		return c.reply("461", cmd, "Not enough parameters")
+	}
+
+	switch cmd {
+	case "PASS", "PONG":
+		// no passwords, pongs only keep the connection alive
+
+	case "PING":
+		token := c.srv.name
+		if len(params) > 0 {
+			token = params[0]
+		}
+		return c.send(c.srv.name, "PONG", c.srv.name, token)
+
+	case "QUIT":
+		c.send("", "ERROR", "Closing link")
+		return errQuit
+
+	case "NICK":
+		nick := nickOf(params[0])
+		c.lck.Lock()
+		registered := c.nick != "" && c.user != ""
+		if !registered {
+			c.nick = nick
+		}
+		c.lck.Unlock()
+		if registered {
+			// the hub binds the user name to the connection
+			return c.reply("447", "Nickname can't be changed, reconnect to use another one")
+		}
+
+	case "USER":
+		return c.reply("462", "You may not reregister")
+
+	case "JOIN":
+		for _, ch := range strings.Split(params[0], ",") {
+			if !strings.HasPrefix(ch, "#") || len(ch) < 2 {
+				c.reply("403", ch, "No such channel")
+				continue
+			}
+			c.queue(chat.T_JOIN, ch[1:], "")
+		}
+
+	case "PART":
+		for _, ch := range strings.Split(params[0], ",") {
+			if id, ok := c.roomOf(ch); ok {
+				c.queue(chat.T_LEAVE, id, "")
+			} else {
+				c.reply("442", ch, "You're not on that channel")
+			}
+		}
+
+	case "PRIVMSG", "NOTICE":
+		if len(params) < 2 || params[1] == "" {
+			return c.reply("412", "No text to send")
+		}
+		target := params[0]
+		if !strings.HasPrefix(target, "#") {
+			// rooms only, sparrow has no direct messages
+			return c.reply("401", target, "No such nick/channel")
+		}
+		id, ok := c.roomOf(target)
+		if !ok {
+			return c.reply("404", target, "Cannot send to channel")
+		}
+		c.queue(chat.T_MESSAGE, id, action(params[1]))
+
+	case "LIST":
+		c.queue(chat.T_ROOMS, "", "")
+
+	case "NAMES":
+		var channels []string
+		if len(params) > 0 {
+			channels = strings.Split(params[0], ",")
+		} else {
+			c.lck.Lock()
+			for _, ch := range c.channels {
+				channels = append(channels, ch)
+			}
+			c.lck.Unlock()
+		}
+		for _, ch := range channels {
+			c.names(ch)
+		}
+
+	case "TOPIC":
+		ch := params[0]
+		rm := c.room(ch)
+		if rm == nil {
+			return c.reply("403", ch, "No such channel")
+		}
+		if len(params) < 2 {
+			return c.topic(ch, rm)
+		}
+		topic := params[1]
+		bs, _ := json.Marshal(&chat.RoomUpdate{Topic: &topic})
+		c.queue(chat.T_UPDATE, rm.ID, string(bs))
+
+	case "MODE":
+		if len(params) > 0 && strings.HasPrefix(params[0], "#") {
+			if len(params) == 1 {
+				return c.reply("324", params[0], "+nt")
+			}
+			return c.reply("482", params[0], "Modes are managed by sparrow")
+		}
+		return c.reply("221", "+")
+
+	case "WHO":
+		mask := "*"
+		if len(params) > 0 {
+			mask = params[0]
+		}
+		return c.reply("315", mask, "End of WHO list")
+
+	default:
+		return c.reply("421", cmd, "Unknown command")
+	}
+	return nil
+}
+
+// room find room of channel, joined or not
+func (c *conn) room(channel string) *chat.RoomInfo {
+	if id, ok := c.roomOf(channel); ok {
+		return c.srv.hub.GetRoom(id)
+	}
+	if !strings.HasPrefix(channel, "#") {
+		return nil
+	}
+	return c.srv.hub.GetRoom(channel[1:])
+}
+
+// names reply online users of channel
+func (c *conn) names(channel string) error {
+	if rm := c.room(channel); rm != nil {
+		clients, _ := c.srv.hub.RoomClients(rm.ID)
+		seen := make(map[string]bool)
+		var nicks []string
+		for _, cl := range clients {
+			if nick := nickOf(cl.User()); !seen[nick] {
+				seen[nick] = true
+				nicks = append(nicks, nick)
+			}
+		}
+		sort.Strings(nicks)
+		if len(nicks) > 0 {
+			c.reply("353", "=", channel, strings.Join(nicks, " "))
+		}
+	}
+	return c.reply("366", channel, "End of NAMES list")
+}
+
+// topic reply topic of channel
+func (c *conn) topic(channel string, rm *chat.RoomInfo) error {
+	if rm.Topic == "" {
+		return c.reply("331", channel, "No topic is set")
+	}
+	return c.reply("332", channel, rm.Topic)
+}
+
+// action turn CTCP ACTION into text web users can read
+func action(text string) string {
+	if strings.HasPrefix(text, "\x01ACTION ") {
+		return "* " + strings.TrimSuffix(strings.TrimPrefix(text, "\x01ACTION "), "\x01")
+	}
+	return text
+}
+
+// WriteMessage translate message of hub into IRC lines
+func (c *conn) WriteMessage(msg *chat.Message) error {
+	if err := c.write(msg); err != nil {
+		return io.EOF
+	}
+	return nil
+}
+
+func (c *conn) write(msg *chat.Message) error {
+	switch msg.Type {
+	case chat.T_JOIN:
+		rm := c.srv.hub.GetRoom(msg.Data)
+		if rm == nil {
+			return nil
+		}
+		ch := "#" + rm.Slug
+		c.lck.Lock()
+		c.channels[rm.ID] = ch
+		c.lck.Unlock()
+		if err := c.send(c.prefix(), "JOIN", ch); err != nil {
+			return err
+		}
+		if rm.Topic != "" {
+			c.topic(ch, rm)
+		}
+		return c.names(ch)
+
+	case chat.T_LEAVE:
+		ch, ok := c.channel(msg.Data)
+		if !ok {
+			return nil
+		}
+		c.lck.Lock()
+		delete(c.channels, msg.Data)
+		c.lck.Unlock()
+		return c.send(c.prefix(), "PART", ch)
+
+	case chat.T_MESSAGE:
+		ch, ok := c.channel(msg.Room)
+		// IRC clients show their own messages already, others of the
+		// same nick are shown
+		if !ok || msg.Source == chat.Conn(c) {
+			return nil
+		}
+		from := nickOf(msg.From)
+		for _, line := range strings.Split(msg.Data, "\n") {
+			if line = strings.TrimRight(line, "\r"); line == "" {
+				continue
+			}
+			if err := c.send(from+"!"+from+"@"+c.srv.name, "PRIVMSG", ch, line); err != nil {
+				return err
+			}
+		}
+
+	case chat.T_SYSTEM:
+		ch, ok := c.channel(msg.Room)
+		if !ok {
+			return nil
+		}
+		user := nickOf(msg.Meta["user"])
+		prefix := user + "!" + user + "@" + c.srv.name
+		switch msg.Event {
+		case chat.E_JOIN, chat.E_LEAVE:
+			// own joins and parts are sent with the replies
+			if msg.Source == chat.Conn(c) {
+				return nil
+			}
+			cmd := "JOIN"
+			if msg.Event == chat.E_LEAVE {
+				cmd = "PART"
+			}
+			return c.send(prefix, cmd, ch)
+		case chat.E_TOPIC:
+			return c.send(prefix, "TOPIC", ch, msg.Meta["value"])
+		default:
+			return c.send(c.srv.name, "NOTICE", ch, msg.Data)
+		}
+
+	case chat.T_CLOSE:
+		ch, ok := c.channel(msg.Room)
+		if !ok {
+			return nil
+		}
+		c.lck.Lock()
+		delete(c.channels, msg.Room)
+		c.lck.Unlock()
+		return c.send(c.srv.name, "KICK", ch, c.getNick(), "Room closed")
+
+	case chat.T_ROOMS:
+		var rooms []*chat.RoomInfo
+		json.Unmarshal([]byte(msg.Data), &rooms)
+		c.reply("321", "Channel", "Users Name")
+		for _, rm := range rooms {
+			c.reply("322", "#"+rm.Slug, strconv.Itoa(int(rm.CCount)), rm.Topic)
+		}
+		return c.reply("323", "End of LIST")
+
+	case chat.T_ERROR:
+		return c.replyError(msg)
+	}
+	return nil
+}
+
+// replyError reply failed command with the closest numeric
+func (c *conn) replyError(msg *chat.Message) error {
+	ch, ok := c.channel(msg.Room)
+	if !ok {
+		ch = "#" + msg.Room
+		if rm := c.srv.hub.GetRoom(msg.Room); rm != nil {
+			ch = "#" + rm.Slug
+		}
+	}
+	switch msg.Data {
+	case chat.ErrRoomNotFound.Error():
+		return c.reply("403", ch, "No such channel")
+	case chat.ErrNotOwner.Error():
+		return c.reply("482", ch, "You're not the room owner")
+	case chat.ErrRateLimited.Error(), chat.ErrMuted.Error():
+		return c.reply("404", ch, fmt.Sprintf("Cannot send to channel (%s)", msg.Data))
+	}
+	return c.send(c.srv.name, "NOTICE", c.getNick(), msg.Data)
+}
+
+// Ping client, it answers with PONG which keeps the connection alive
+func (c *conn) Ping() error {
+	if err := c.send("", "PING", c.srv.name); err != nil {
+		return io.EOF
+	}
+	return nil
+}
+
+func (c *conn) Close() error {
+	return c.nc.Close()
+}
+
+func (c *conn) RemoteAddr() string {
+	return c.nc.RemoteAddr().String()
+}
@@ -0,0 +1,109 @@
+// Package irc is a gateway speaking a subset of the IRC protocol, so users
+// of terminal IRC clients chat in sparrow rooms with web users. Channels
+// are rooms named "#" and the room slug, each IRC connection is a client
+// of the room hub.
+package irc
+
+import (
+	"net"
+	"strings"
+
+	"../chat"
+	"../gateway"
+	"../logging"
+)
+
+var log = logging.Logger(logging.IRC)
+
+// Options of the IRC gateway
+type Options struct {
+	Addr string `toml:"addr" yaml:"addr"` // listen address, e.g. :6667, disabled if empty
+	Name string `toml:"name" yaml:"name"` // server name in replies
+}
+
+// DefaultOptions of the IRC gateway, disabled
+var DefaultOptions = Options{
+	Name: "sparrow",
+}
+
+// ErrServerClosed returned by Serve after Close
+var ErrServerClosed = gateway.ErrServerClosed
+
+// Server accepts IRC connections and serves them as clients of hub
+type Server struct {
+	*gateway.Listener
+	hub  *chat.RoomHub
+	name string
+}
+
+// NewServer create IRC gateway of hub
+func NewServer(hub *chat.RoomHub, opts Options) *Server {
+	name := opts.Name
+	if name == "" {
+		name = DefaultOptions.Name
+	}
+	s := &Server{hub: hub, name: name}
+	s.Listener = gateway.NewListener("irc gateway", log, s.serveConn)
+	return s
+}
+
+// serveConn register the connection, then serve it as a hub client
+func (s *Server) serveConn(nc net.Conn) {
+	c := newConn(s, nc)
+	if err := c.register(); err != nil {
+		log.Debug("irc registration failed", "addr", c.RemoteAddr(), "err", err)
+		nc.Close()
+		return
+	}
+	client, err := s.hub.Connect(c)
+	if err != nil {
+		log.Debug("irc client rejected", "nick", c.getNick(), "addr", c.RemoteAddr(), "err", err)
+		return
+	}
+	log.Debug("irc client connected", "client", client.ID(), "nick", c.getNick(), "addr", c.RemoteAddr())
+}
+
+// parse split IRC line into command and parameters,
+// the prefix sent by clients is ignored.
+func parse(line string) (string, []string) {
+	line = strings.TrimLeft(line, " ")
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return "", nil
+		}
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+
+	var params []string
+	trailing := ""
+	hasTrailing := false
+	if i := strings.Index(line, " :"); i >= 0 {
+		trailing, hasTrailing = line[i+2:], true
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	params = fields[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	return strings.ToUpper(fields[0]), params
+}
+
+// nickOf turn a sparrow user name into a valid nick
+func nickOf(name string) string {
+	nick := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', ',', '*', '?', '!', '@', ':', '#', '&', '\r', '\n', 0:
+			return '_'
+		}
+		return r
+	}, name)
+	if nick == "" {
+		return "anonymous"
+	}
+	return nick
+}
@@ -0,0 +1,142 @@
+package irc
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"../chat"
+	"../gateway/gatewaytest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cmd, params := parse("privmsg #general :hello : world")
+	assert.Equal(t, "PRIVMSG", cmd)
+	assert.Equal(t, []string{"#general", "hello : world"}, params)
+
+	cmd, params = parse(":alice!a@host JOIN #a,#b")
+	assert.Equal(t, "JOIN", cmd)
+	assert.Equal(t, []string{"#a,#b"}, params)
+
+	cmd, params = parse("NICK :bob")
+	assert.Equal(t, "NICK", cmd)
+	assert.Equal(t, []string{"bob"}, params)
+
+	cmd, _ = parse(":prefix")
+	assert.Equal(t, "", cmd)
+	assert.Equal(t, "小王-默认", nickOf("小王-默认"))
+	assert.Equal(t, "a_b", nickOf("a b"))
+	assert.Equal(t, "anonymous", nickOf(""))
+}
+
+func TestGateway(t *testing.T) {
+	hub := chat.NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := NewServer(hub, DefaultOptions)
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// web user
+	local, web := chat.Pipe()
+	hub.Connect(local)
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_JOIN, From: "web", Room: "general"}))
+
+	c := gatewaytest.Dial(t, ln.Addr().String(), "\r\n")
+	defer c.Close()
+	c.Send("JOIN #general")
+	c.Expect(" 451 ")
+	c.Send("CAP LS 302")
+	c.Expect(" 421 ")
+	c.Send("NICK alice")
+	c.Send("USER alice 0 * :Alice")
+	c.Expect(" 001 alice ")
+	c.Expect(" 422 ")
+
+	c.Send("NICK bob")
+	c.Expect(" 447 alice ")
+	c.Send("PING :abc")
+	assert.Equal(t, ":sparrow PONG sparrow :abc", c.Expect("PONG"))
+
+	c.Send("LIST")
+	assert.Contains(t, c.Expect(" 322 "), "#general 1")
+	c.Expect(" 323 ")
+
+	c.Send("JOIN #general,#nope")
+	assert.Equal(t, ":alice!alice@sparrow JOIN :#general", c.Expect("JOIN"))
+	assert.Equal(t, ":sparrow 353 alice = #general :alice web", c.Expect(" 353 "))
+	c.Expect(" 366 ")
+	c.Expect(" 403 alice #nope ")
+	c.Send("TOPIC #general")
+	c.Expect(" 331 ")
+
+	c.Send("PRIVMSG #general :" + strings.Repeat("x", 600))
+	c.Expect(" 417 alice ")
+
+	// irc to web
+	c.Send("PRIVMSG #general :hi from irc")
+	for {
+		msg, err := web.ReadMessage()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if msg.Type == chat.T_MESSAGE {
+			assert.Equal(t, "alice", msg.From)
+			assert.Equal(t, rm.ID, msg.Room)
+			assert.Equal(t, "hi from irc", msg.Data)
+			break
+		}
+	}
+
+	// web to irc, read web messages so the pipe isn't blocked
+	go func() {
+		for {
+			if _, err := web.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_MESSAGE, From: "web", Room: rm.ID, Data: "hello\nirc"}))
+	assert.Equal(t, ":web!web@sparrow PRIVMSG #general :hello", c.Expect("PRIVMSG"))
+	assert.Equal(t, ":web!web@sparrow PRIVMSG #general :irc", c.Expect("PRIVMSG"))
+
+	// only own messages are not echoed, not those of others of the same name
+	local2, web2 := chat.Pipe()
+	hub.Connect(local2)
+	go func() {
+		for {
+			if _, err := web2.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	assert.NoError(t, web2.WriteMessage(&chat.Message{Type: chat.T_JOIN, From: "alice", Room: "general"}))
+	assert.NoError(t, web2.WriteMessage(&chat.Message{Type: chat.T_MESSAGE, From: "alice", Room: rm.ID, Data: "same nick"}))
+	assert.Equal(t, ":alice!alice@sparrow PRIVMSG #general :same nick", c.Expect("PRIVMSG"))
+	web2.Close()
+	c.Expect(":alice!alice@sparrow PART :#general")
+
+	c.Send("PRIVMSG #random :hi")
+	c.Expect(" 404 ")
+	c.Send("PRIVMSG bob :hi")
+	c.Expect(" 401 ")
+	c.Send("FOO")
+	c.Expect(" 421 alice FOO ")
+
+	web.Close()
+	c.Expect(":web!web@sparrow PART :#general")
+
+	c.Send("PART #general")
+	assert.Equal(t, ":alice!alice@sparrow PART :#general", c.Expect("PART"))
+	c.Send("QUIT")
+	c.Expect("ERROR")
+	assert.Eventually(t, func() bool {
+		return len(hub.Clients()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
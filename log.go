@@ -0,0 +1,8 @@
+package main
+
+import "./logging"
+
+var (
+	mainLog = logging.Logger(logging.Main)
+	httpLog = logging.Logger(logging.HTTP)
+)
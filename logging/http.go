@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// LevelHandler serve levels of subsystems, GET returns levels as json,
+// PUT or POST changes them with a json object like {"client": "debug"},
+// or query parameters ?subsystem=client&level=debug, subsystem "*"
+// changes the default level. Subsystems not in Subsystems are rejected.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut, http.MethodPost:
+			levels := map[string]string{}
+			if q := r.URL.Query(); q.Get("level") != "" {
+				levels[q.Get("subsystem")] = q.Get("level")
+			} else if err := json.NewDecoder(r.Body).Decode(&levels); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid levels: "+err.Error())
+				return
+			}
+			// validate all before changing any, unknown names would
+			// register subsystems nothing logs to
+			known := map[string]bool{"*": true}
+			for _, name := range Subsystems() {
+				known[name] = true
+			}
+			for name, level := range levels {
+				if !known[name] {
+					writeError(w, http.StatusBadRequest, "unknown subsystem "+strconv.Quote(name))
+					return
+				}
+				if _, err := ParseLevel(level); err != nil {
+					writeError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+			// default level first, so explicit levels are kept
+			if level, ok := levels["*"]; ok {
+				SetLevel("*", level)
+				delete(levels, "*")
+			}
+			for name, level := range levels {
+				SetLevel(name, level)
+			}
+			Logger(HTTP).Info("log levels changed", "levels", levels, "remote", r.RemoteAddr)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Levels())
+	})
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
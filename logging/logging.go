@@ -0,0 +1,306 @@
+// Package logging provides structured leveled loggers for subsystems,
+// levels of each subsystem can be changed at runtime, and debug logs of
+// hot paths are sampled.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LevelTrace is more verbose than debug, for logs of every message
+const LevelTrace = slog.Level(-8)
+
+// subsystems
+const (
+	Main   = "main"
+	Hub    = "hub"
+	Room   = "room"
+	Client = "client"
+	Queue  = "queue"
+	HTTP   = "http"
+	IRC    = "irc"
+	MQTT   = "mqtt"
+	Telnet = "telnet"
+	RPC    = "rpc"
+)
+
+// log formats
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+// Sampling of logs below info level, in each tick the first Initial
+// records with the same message are logged, then every Thereafter-th.
+type Sampling struct {
+	Initial    int           `toml:"initial" yaml:"initial"`
+	Thereafter int           `toml:"thereafter" yaml:"thereafter"`
+	Tick       time.Duration `toml:"tick" yaml:"tick"`
+}
+
+// DefaultSampling logs 100 records a second of each message, then 1 of 100
+var DefaultSampling = Sampling{
+	Initial:    100,
+	Thereafter: 100,
+	Tick:       time.Second,
+}
+
+// Options of logging
+type Options struct {
+	Format   string            // logfmt or json
+	Output   io.Writer         // stderr if nil
+	Level    string            // default level of subsystems
+	Levels   map[string]string // levels of subsystems
+	Sampling Sampling          // sampling of debug logs, disabled if Initial is 0
+}
+
+// registry of subsystem levels, shared by all loggers
+type registry struct {
+	lck      sync.RWMutex
+	base     slog.Handler
+	level    slog.Level // default level of subsystems
+	levels   map[string]*slog.LevelVar
+	explicit map[string]bool // levels set explicitly, not following the default
+	sampler  *sampler
+}
+
+var reg = &registry{
+	base:     newHandler(FormatLogfmt, os.Stderr),
+	level:    slog.LevelInfo,
+	levels:   make(map[string]*slog.LevelVar),
+	explicit: make(map[string]bool),
+}
+
+func init() {
+	for _, name := range []string{Main, Hub, Room, Client, Queue, HTTP, IRC, MQTT, Telnet, RPC} {
+		reg.levelVar(name)
+	}
+}
+
+func newHandler(format string, out io.Writer) slog.Handler {
+	opts := &slog.HandlerOptions{
+		// levels are checked by subsystem handlers
+		Level: LevelTrace,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				if lv, ok := a.Value.Any().(slog.Level); ok {
+					a.Value = slog.StringValue(LevelName(lv))
+				}
+			}
+			return a
+		},
+	}
+	if format == FormatJSON {
+		return slog.NewJSONHandler(out, opts)
+	}
+	return slog.NewTextHandler(out, opts)
+}
+
+// levelVar return level of subsystem, created with default level
+func (r *registry) levelVar(name string) *slog.LevelVar {
+	r.lck.RLock()
+	lv, ok := r.levels[name]
+	r.lck.RUnlock()
+	if ok {
+		return lv
+	}
+
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if lv, ok = r.levels[name]; !ok {
+		lv = new(slog.LevelVar)
+		lv.Set(r.level)
+		r.levels[name] = lv
+	}
+	return lv
+}
+
+func (r *registry) handler() (slog.Handler, *sampler) {
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+	return r.base, r.sampler
+}
+
+// Setup logging with options, loggers created before keep working
+// with the new settings.
+func Setup(opts Options) error {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	levels := make(map[string]slog.Level, len(opts.Levels))
+	for name, s := range opts.Levels {
+		if levels[name], err = ParseLevel(s); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	switch opts.Format {
+	case "", FormatLogfmt, FormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q", opts.Format)
+	}
+	out := opts.Output
+	if out == nil {
+		out = os.Stderr
+	}
+
+	reg.lck.Lock()
+	reg.base = newHandler(opts.Format, out)
+	reg.sampler = newSampler(opts.Sampling)
+	reg.level = level
+	reg.explicit = make(map[string]bool, len(levels))
+	for name := range levels {
+		reg.explicit[name] = true
+	}
+	reg.lck.Unlock()
+
+	for _, name := range Subsystems() {
+		reg.levelVar(name).Set(level)
+	}
+	for name, l := range levels {
+		reg.levelVar(name).Set(l)
+	}
+	return nil
+}
+
+// SetLevel change level of subsystem at runtime, subsystem "*" or empty
+// changes the default level and levels not set explicitly.
+func SetLevel(subsystem, level string) error {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if subsystem == "" || subsystem == "*" {
+		reg.lck.Lock()
+		reg.level = l
+		for name, lv := range reg.levels {
+			if !reg.explicit[name] {
+				lv.Set(l)
+			}
+		}
+		reg.lck.Unlock()
+		return nil
+	}
+
+	reg.levelVar(subsystem).Set(l)
+	reg.lck.Lock()
+	reg.explicit[subsystem] = true
+	reg.lck.Unlock()
+	return nil
+}
+
+// Levels return levels of subsystems, the default level is "*"
+func Levels() map[string]string {
+	reg.lck.RLock()
+	defer reg.lck.RUnlock()
+	res := map[string]string{"*": LevelName(reg.level)}
+	for name, lv := range reg.levels {
+		res[name] = LevelName(lv.Level())
+	}
+	return res
+}
+
+// Subsystems return names of registered subsystems
+func Subsystems() []string {
+	reg.lck.RLock()
+	defer reg.lck.RUnlock()
+	names := make([]string, 0, len(reg.levels))
+	for name := range reg.levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseLevel parse level name: trace, debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "trace":
+		return LevelTrace, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error", "fatal":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// LevelName return lower case name of level
+func LevelName(l slog.Level) string {
+	switch {
+	case l <= LevelTrace:
+		return "trace"
+	case l <= slog.LevelDebug:
+		return "debug"
+	case l <= slog.LevelInfo:
+		return "info"
+	case l <= slog.LevelWarn:
+		return "warn"
+	}
+	return "error"
+}
+
+// Logger return logger of subsystem, records carry the subsystem name
+func Logger(subsystem string) *slog.Logger {
+	return slog.New(&handler{
+		subsystem: subsystem,
+		level:     reg.levelVar(subsystem),
+	})
+}
+
+// Fatal log error and exit
+func Fatal(l *slog.Logger, msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
+
+// handler checks subsystem level, samples and writes records with
+// the current base handler.
+type handler struct {
+	subsystem string
+	level     *slog.LevelVar
+	ops       []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	base, s := reg.handler()
+	if s != nil && r.Level < slog.LevelInfo && !s.sample(h.subsystem, r.Message, r.Time) {
+		return nil
+	}
+
+	var out slog.Handler = base.WithAttrs([]slog.Attr{slog.String("subsystem", h.subsystem)})
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{subsystem: h.subsystem, level: h.level, ops: append(ops, op)}
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(base slog.Handler) slog.Handler { return base.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(base slog.Handler) slog.Handler { return base.WithGroup(name) })
+}
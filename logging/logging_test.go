@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setup logging into a buffer, returns decoded records
+func setup(t *testing.T, opts Options) func() []map[string]any {
+	var buf bytes.Buffer
+	opts.Format = FormatJSON
+	opts.Output = &buf
+	assert.NoError(t, Setup(opts))
+	t.Cleanup(func() { Setup(Options{}) })
+
+	return func() []map[string]any {
+		var res []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			rec := map[string]any{}
+			assert.NoError(t, json.Unmarshal([]byte(line), &rec))
+			res = append(res, rec)
+		}
+		buf.Reset()
+		return res
+	}
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	records := setup(t, Options{Level: "info", Levels: map[string]string{Client: "debug"}})
+	client, hub := Logger(Client), Logger(Hub)
+
+	client.Debug("client debug", "room", "lobby")
+	hub.Debug("hub debug")
+	hub.Info("hub info")
+	recs := records()
+	if assert.Len(t, recs, 2) {
+		assert.Equal(t, "client debug", recs[0]["msg"])
+		assert.Equal(t, "debug", recs[0]["level"])
+		assert.Equal(t, Client, recs[0]["subsystem"])
+		assert.Equal(t, "lobby", recs[0]["room"])
+		assert.Equal(t, Hub, recs[1]["subsystem"])
+	}
+
+	// default level changes subsystems not set explicitly
+	assert.NoError(t, SetLevel("*", "error"))
+	assert.Equal(t, "error", Levels()[Hub])
+	assert.Equal(t, "debug", Levels()[Client])
+
+	assert.NoError(t, SetLevel(Hub, "trace"))
+	hub.Log(context.Background(), LevelTrace, "hub trace")
+	client.Debug("client debug")
+	assert.Len(t, records(), 2)
+
+	assert.Error(t, SetLevel(Hub, "verbose"))
+	assert.Error(t, Setup(Options{Format: "xml"}))
+}
+
+func TestWith(t *testing.T) {
+	records := setup(t, Options{Level: "info"})
+	l := Logger(Room).With("room", "lobby").WithGroup("msg")
+	l.Info("sent", "id", 1)
+
+	recs := records()
+	if assert.Len(t, recs, 1) {
+		assert.Equal(t, "lobby", recs[0]["room"])
+		assert.Equal(t, map[string]any{"id": float64(1)}, recs[0]["msg"])
+	}
+}
+
+func TestSampling(t *testing.T) {
+	records := setup(t, Options{Level: "trace", Sampling: Sampling{Initial: 2, Thereafter: 3, Tick: time.Hour}})
+	l := Logger(Queue)
+	for i := 0; i < 8; i++ {
+		l.Debug("queued")
+	}
+	// infos are never sampled
+	for i := 0; i < 3; i++ {
+		l.Info("full")
+	}
+	// 1, 2, 5, 8 of debugs
+	assert.Len(t, records(), 4+3)
+
+	s := newSampler(Sampling{Initial: 1, Tick: time.Second})
+	now := time.Now()
+	assert.True(t, s.sample(Queue, "a", now))
+	assert.False(t, s.sample(Queue, "a", now))
+	assert.True(t, s.sample(Queue, "b", now))
+	assert.True(t, s.sample(Queue, "a", now.Add(time.Second)))
+	assert.Nil(t, newSampler(Sampling{}))
+}
+
+func TestLevelHandler(t *testing.T) {
+	setup(t, Options{Level: "info"})
+	h := LevelHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	levels := map[string]string{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &levels))
+	assert.Equal(t, "info", levels["*"])
+	assert.Equal(t, "info", levels[Client])
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"*": "warn", "client": "trace"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "warn", Levels()[Hub])
+	assert.Equal(t, "trace", Levels()[Client])
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/?subsystem=hub&level=debug", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "debug", Levels()[Hub])
+
+	// invalid levels change nothing
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"room": "debug", "hub": "loud"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"error"`)
+	assert.Equal(t, "warn", Levels()[Room])
+
+	// so do unknown subsystems
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/?subsystem=hbu&level=debug", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unknown subsystem")
+	assert.NotContains(t, Subsystems(), "hbu")
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
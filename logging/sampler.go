@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// sampler counts records of each message in current tick
+type sampler struct {
+	Sampling
+
+	lck    sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+func newSampler(s Sampling) *sampler {
+	if s.Initial <= 0 {
+		return nil
+	}
+	if s.Tick <= 0 {
+		s.Tick = time.Second
+	}
+	return &sampler{Sampling: s, counts: make(map[string]int)}
+}
+
+// sample return whether the record should be logged
+func (s *sampler) sample(subsystem, msg string, now time.Time) bool {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	if now.Sub(s.start) >= s.Tick || now.Before(s.start) {
+		s.start = now
+		s.counts = make(map[string]int, len(s.counts))
+	}
+	key := subsystem + "\x00" + msg
+	n := s.counts[key] + 1
+	s.counts[key] = n
+
+	if n <= s.Initial {
+		return true
+	}
+	return s.Thereafter > 0 && (n-s.Initial)%s.Thereafter == 0
+}
@@ -1,71 +1,317 @@
 package main
 
 import (
-	"flag"
 	"./chat"
-	_ "net/http/pprof"
-
-	"github.com/go-clog/clog"
-	"github.com/gorilla/mux"
+	"./irc"
+	"./logging"
+	"./mqtt"
+	"./rpc"
+	"./telnet"
+	"context"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"gitlab.com/jinfagang/colorgo"
-	"net"
-	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-func GetLocalIPAddr()  {
+// GetLocalIPAddr return non-loopback IPv4 addresses of this host
+func GetLocalIPAddr() []string {
+	var ips []string
 	addr, _ := net.InterfaceAddrs()
 	for _, add := range addr {
-		if ipNet, ok := add.(*net.IPNet); ok && ! ipNet.IP.IsLoopback() {
+		if ipNet, ok := add.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
 			if ipNet.IP.To4() != nil {
-				fmt.Println(ipNet.IP.String())
+				ips = append(ips, ipNet.IP.String())
 			}
 		}
 	}
+	return ips
+}
+
+// certHosts return hosts self-signed certificate should cover
+func certHosts() []string {
+	return append([]string{"localhost", "127.0.0.1"}, GetLocalIPAddr()...)
 }
 
-func init() {
-	clog.New(clog.CONSOLE, clog.ConsoleConfig{
-		Level:      clog.TRACE,
-		BufferSize: 1024,
-	})
+// originPolicy of the config
+func originPolicy(cfg *Config) chat.OriginPolicy {
+	return chat.OriginPolicy{Allowed: cfg.Origins, Dev: cfg.Dev}
+}
+
+// createRooms create rooms in config if they don't exist
+func createRooms(hub *chat.RoomHub, rooms []string) {
+	for _, name := range rooms {
+		if _, err := hub.NewRoom(name, ""); err != nil && err != chat.ErrRoomExists {
+			mainLog.Error("create room failed", "room", name, "err", err)
+		}
+	}
+}
+
+// reloadOnHangup reload safe settings from config file on SIGHUP,
+// other settings take effect after restart.
+func reloadOnHangup(hub *chat.RoomHub, limiter *chat.RateLimiter, site *Site) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		cfg := site.Config()
+		next, err := LoadConfig(os.Args[1:])
+		if err != nil {
+			mainLog.Error("reload config failed, keep the old one", "err", err)
+			continue
+		}
+
+		if err := logging.Setup(next.Logging()); err != nil {
+			mainLog.Error("reload log settings failed", "err", err)
+		}
+		limiter.SetLimits(next.Limits)
+		hub.SetOriginPolicy(originPolicy(next))
+		createRooms(hub, next.Rooms)
+		site.SetConfig(next)
+
+		if next.Addr != cfg.Addr || next.DataDir != cfg.DataDir || next.PublicDir != cfg.PublicDir ||
+			next.TLS != cfg.TLS || next.Chat != cfg.Chat || next.Discovery != cfg.Discovery ||
+			next.AccessLog != cfg.AccessLog || next.AccessLogFile != cfg.AccessLogFile || next.LogFormat != cfg.LogFormat || next.Admin != cfg.Admin ||
+			next.IRC != cfg.IRC || next.MQTT != cfg.MQTT || next.Telnet != cfg.Telnet || next.GRPC != cfg.GRPC {
+			mainLog.Warn("addr, data_dir, public_dir, tls, chat, discovery, log format, admin, irc, mqtt, telnet, grpc and access log settings changed, restart to apply them")
+		}
+		mainLog.Info("config reloaded")
+	}
+}
+
+// serveAdmin serve admin router on the admin listener, a token is
+// generated in data directory if no credentials are configured.
+func serveAdmin(cfg *Config, r http.Handler, accessLog *AccessLog) {
+	creds := cfg.Admin
+	if creds.Token == "" && creds.User == "" {
+		file := filepath.Join(cfg.DataDir, "admin.token")
+		token, err := adminToken(file)
+		if err != nil {
+			logging.Fatal(mainLog, "create admin token failed", "file", file, "err", err)
+		}
+		creds.Token = token
+		mainLog.Info("admin token generated", "file", file)
+	}
+	if host, _, _ := net.SplitHostPort(cfg.Admin.Addr); host == "" || !net.ParseIP(host).IsLoopback() && host != "localhost" {
+		mainLog.Warn("admin listener is reachable from other hosts", "addr", cfg.Admin.Addr)
+	}
+
+	// bind before serving, so a taken port stops the server at start
+	ln, err := net.Listen("tcp", cfg.Admin.Addr)
+	if err != nil {
+		logging.Fatal(mainLog, "admin listen failed", "addr", cfg.Admin.Addr, "err", err)
+	}
+	handler := Handler(AdminAuth(creds, r), accessLog)
+	go func() {
+		mainLog.Info("admin listener stopped", "err", http.Serve(ln, handler))
+	}()
+	mainLog.Info("admin listening", "addr", ln.Addr().String())
 }
 
 func main() {
-	var addr string
-	flag.StringVar(&addr, "addr", ":9090", "http service address")
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		os.Exit(discoverCmd(os.Args[2:]))
+	}
+
+	cfg, err := LoadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := logging.Setup(cfg.Logging()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	cg.PrintlnGreen("=> Starting sparrow, serves all the messages...")
-	cg.PrintlnGreen("=> Now sparrow on serving. Listen to port: " + addr)
+	cg.PrintlnGreen("=> Now sparrow on serving. Listen to port: " + cfg.Addr)
 	// get local ip, this can be add as local server
 	fmt.Println()
 	cg.PrintlnBlue("=> For local group chat, using local address below (one of them):")
-	GetLocalIPAddr()
+	for _, ip := range GetLocalIPAddr() {
+		fmt.Println(ip)
+	}
+	cg.PrintlnBlue("=> Or find this server with `sparrow discover` on other machines.")
+	if cfg.QR {
+		fmt.Println()
+		cg.PrintlnBlue("=> Scan QR code below to join on phones:")
+		printQRCodes(cfg)
+	}
 
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		logging.Fatal(mainLog, "create data directory failed", "dir", cfg.DataDir, "err", err)
+	}
+	store, err := chat.NewFileStore(filepath.Join(cfg.DataDir, "rooms.json"))
+	if err != nil {
+		logging.Fatal(mainLog, "open room store failed", "err", err)
+	}
+
+	if cfg.Dev {
+		mainLog.Warn("dev mode on, websocket connections from any origin are allowed")
+	}
 
 	hub := chat.NewChatHub()
-	hub.NewRoom("默认聊天组")
+	hub.SetOptions(cfg.Chat)
+	hub.SetOriginPolicy(originPolicy(cfg))
+	hub.SetStore(store)
+	if err = hub.LoadRooms(); err != nil {
+		logging.Fatal(mainLog, "load rooms failed", "err", err)
+	}
+	createRooms(hub, cfg.Rooms)
+
+	limiter := chat.NewRateLimiter(cfg.Limits)
+	limiter.Install(hub)
+	expvar.Publish("ratelimit", limiter.Vars())
+
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(
+		hub.Metrics(),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	if ad := advertise(cfg, func() int { return len(hub.RoomList()) }); ad != nil {
+		defer ad.Close()
+	}
+
+	site := NewSite(cfg)
+	go reloadOnHangup(hub, limiter, site)
 
 	hub.AddHandlers(
 		func(msg *chat.Message, hub *chat.RoomHub) {
 			if msg.Type == chat.T_MESSAGE {
-				clog.Trace("Got a msg: %+v", msg)
+				// sampled, every message passes here
+				mainLog.Log(context.Background(), logging.LevelTrace, "got a message",
+					"user", msg.From, "room", msg.Room, "msg_id", msg.ID, "type", msg.Type)
 			} else {
-				clog.Info("cmd: %s, from: %s", msg.Type, msg.From)
+				mainLog.Debug("got a command", "user", msg.From, "room", msg.Room, "type", msg.Type)
 			}
 		},
 	)
 
-	r := mux.NewRouter()
-	r.StrictSlash(false)
-	r.HandleFunc("/", IndexHandle)
+	assets, err := NewAssets(cfg.PublicDir)
+	if err != nil {
+		logging.Fatal(mainLog, "load web assets failed", "err", err)
+	}
+
+	accessOut := io.Writer(os.Stdout)
+	if cfg.AccessLogFile != "" {
+		f, err := os.OpenFile(cfg.AccessLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			logging.Fatal(mainLog, "open access log failed", "file", cfg.AccessLogFile, "err", err)
+		}
+		defer f.Close()
+		accessOut = f
+	}
+	accessLog, err := NewAccessLog(cfg.AccessLog, accessOut)
+	if err != nil {
+		logging.Fatal(mainLog, "create access log failed", "err", err)
+	}
+
+	r := NewRouter(assets)
+	r.HandleFunc("/", IndexHandle(assets, site))
+	r.HandleFunc("/config.json", site.ConfigHandle)
+	r.HandleFunc("/qr.png", site.QRHandle)
 	r.HandleFunc("/ws", hub.ServeWebsocket)
-	r.PathPrefix("/public/").Handler(http.StripPrefix("/public/", http.FileServer(http.Dir("./public"))))
+	// fallbacks for networks blocking websocket
+	r.HandleFunc("/sse", hub.ServeSSE).Methods(http.MethodGet)
+	r.HandleFunc("/poll", hub.ServePoll).Methods(http.MethodGet)
+	r.HandleFunc("/send", hub.ServeSend).Methods(http.MethodPost)
+	r.HandleFunc("/healthz", HealthHandle)
+	r.HandleFunc("/readyz", ReadyHandle(hub))
+	NewAPI(hub, site).Register(r)
+	handler := Handler(r, accessLog)
+
+	if cfg.Admin.Addr != "" {
+		serveAdmin(cfg, NewAdminRouter(hub, metrics), accessLog)
+	}
+	if cfg.IRC.Addr != "" {
+		go func() {
+			mainLog.Error("irc gateway stopped", "err", irc.NewServer(hub, cfg.IRC).ListenAndServe(cfg.IRC.Addr))
+		}()
+	}
+	if cfg.MQTT.Addr != "" {
+		go func() {
+			mainLog.Error("mqtt broker stopped", "err", mqtt.NewServer(hub, cfg.MQTT).ListenAndServe(cfg.MQTT.Addr))
+		}()
+	}
+	if cfg.Telnet.Addr != "" {
+		go func() {
+			mainLog.Error("telnet listener stopped", "err", telnet.NewServer(hub, cfg.Telnet).ListenAndServe(cfg.Telnet.Addr))
+		}()
+	}
 
 	//http.HandleFunc("/", serveHome)
 	//http.HandleFunc("/ws", hub.ServeWebsocket)
 
-	defer clog.Shutdown()
-	clog.Info("%v", http.ListenAndServe(addr, r))
+	addr := cfg.Addr
+	certFile, keyFile := cfg.TLS.Cert, cfg.TLS.Key
+	certDir := filepath.Join(cfg.DataDir, "tls")
+	if cfg.TLS.Auto && certFile == "" {
+		if certFile, keyFile, err = SelfSignedCert(certDir, certHosts()); err != nil {
+			logging.Fatal(mainLog, "create self-signed certificate failed", "err", err)
+		}
+		cg.PrintlnBlue("=> Using self-signed certificate, trust the CA below on your devices:")
+		fmt.Printf("%s\nSHA-256 %s\n", filepath.Join(certDir, "ca.pem"), CAFingerprint(certDir))
+
+		// LAN addresses may change, renew the certificate for new ones
+		go func() {
+			for range time.Tick(time.Hour) {
+				if _, _, err := SelfSignedCert(certDir, certHosts()); err != nil {
+					mainLog.Error("renew self-signed certificate failed", "err", err)
+				}
+			}
+		}()
+	}
+
+	var loader *CertLoader
+	if certFile != "" && keyFile != "" {
+		if loader, err = NewCertLoader(certFile, keyFile); err != nil {
+			logging.Fatal(mainLog, "load certificate failed", "cert", certFile, "err", err)
+		}
+	}
+
+	if cfg.GRPC.Addr != "" {
+		tokens := func() []string { return site.Config().API.Tokens }
+		var opts []grpc.ServerOption
+		if loader != nil {
+			// same certificate as https, reloaded when renewed
+			opts = append(opts, grpc.Creds(credentials.NewTLS(loader.TLSConfig())))
+		}
+		go func() {
+			mainLog.Error("grpc server stopped", "err", rpc.NewServer(hub, cfg.GRPC, tokens, opts...).ListenAndServe(cfg.GRPC.Addr))
+		}()
+	}
+
+	if loader == nil {
+		mainLog.Info("server stopped", "err", http.ListenAndServe(addr, handler))
+		return
+	}
+	if cfg.TLS.Redirect != "" {
+		go func() {
+			mainLog.Info("https redirect stopped", "err", http.ListenAndServe(cfg.TLS.Redirect, RedirectHTTPS(addr)))
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: loader.TLSConfig(),
+	}
+	mainLog.Info("server stopped", "err", srv.ListenAndServeTLS("", ""))
 }
@@ -0,0 +1,459 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"../chat"
+	"../gateway"
+	"../std"
+)
+
+const (
+	// time allowed to send CONNECT after connected
+	connectWait = 10 * time.Second
+	// QoS 1 publishes waiting for PUBACK, slower clients are disconnected
+	maxInflight = 64
+)
+
+var (
+	errProtocol = errors.New("mqtt: protocol violation")
+	errQoS2     = errors.New("mqtt: QoS 2 not supported")
+	errInflight = errors.New("mqtt: too many publishes not acknowledged")
+)
+
+// subscription of a room
+type subscription struct {
+	topic string // topic messages are published with
+	qos   byte   // granted QoS
+}
+
+// inflight QoS 1 publish waiting for PUBACK
+type inflight struct {
+	id   uint16
+	p    *packet
+	sent time.Time
+}
+
+// conn is the chat.Conn of a MQTT client, publishes are translated into
+// messages of the hub, and room messages into publishes of subscribed
+// topics. Packets without a hub message, e.g. PINGREQ, are answered directly.
+type conn struct {
+	srv  *Server
+	nc   net.Conn
+	opts chat.Options
+	r    *bufio.Reader
+
+	id        string        // client id
+	user      string        // user name of messages, client id if no username
+	keepAlive time.Duration // client sends something within it, 0 disables
+
+	wlck     sync.Mutex // guards writes, packet ids and in-flight publishes
+	w        *bufio.Writer
+	nextID   uint16
+	inflight []*inflight // oldest first
+
+	lck  sync.Mutex
+	subs map[string]subscription // room id -> subscription
+
+	*gateway.Queue // messages of packets not read yet
+	once           sync.Once
+}
+
+func newConn(srv *Server, nc net.Conn) *conn {
+	c := &conn{
+		srv:  srv,
+		nc:   nc,
+		opts: srv.hub.Options(),
+		r:    bufio.NewReader(nc),
+		w:    bufio.NewWriter(nc),
+		subs: make(map[string]subscription),
+	}
+	c.Queue = gateway.NewQueue(c.next, c.opts.MaxMessageSize)
+	return c
+}
+
+// write packet to client
+func (c *conn) write(p *packet) error {
+	c.wlck.Lock()
+	defer c.wlck.Unlock()
+	return c.writeLocked(p)
+}
+
+func (c *conn) writeLocked(p *packet) error {
+	c.nc.SetWriteDeadline(time.Now().Add(c.opts.WriteWait))
+	if _, err := c.w.Write(p.encode()); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// connect read CONNECT and accept or refuse it
+func (c *conn) connect() error {
+	c.nc.SetReadDeadline(time.Now().Add(connectWait))
+	p, err := readPacket(c.r)
+	if err != nil {
+		return err
+	}
+	if p.typ != pktConnect {
+		return errProtocol
+	}
+
+	rd := &reader{buf: p.body}
+	proto, level, flags := rd.string(), rd.byte(), rd.byte()
+	keepAlive := rd.uint16()
+	id := rd.string()
+	if rd.err != nil {
+		return rd.err
+	}
+	if proto != "MQTT" || level != 4 {
+		c.connack(codeBadProtocol)
+		return errProtocol
+	}
+	if flags&0x01 != 0 {
+		return errMalformed
+	}
+	if flags&0x04 != 0 {
+		// wills are not published
+		rd.string()
+		rd.bytes()
+	}
+	var user, password string
+	if flags&0x80 != 0 {
+		user = rd.string()
+	}
+	if flags&0x40 != 0 {
+		password = rd.string()
+	}
+	if rd.err != nil {
+		return rd.err
+	}
+
+	if flags&0x02 == 0 {
+		// sessions end with connections, they can't be resumed
+		c.connack(codeBadIdentifier)
+		return errors.New("mqtt: persistent sessions not supported")
+	}
+	if id == "" {
+		id = std.GenUIDs()
+	}
+	if c.srv.password != "" && subtle.ConstantTimeCompare([]byte(password), []byte(c.srv.password)) != 1 {
+		c.connack(codeBadCredentials)
+		return errors.New("mqtt: bad password")
+	}
+	if user == "" {
+		user = id
+	}
+
+	c.id, c.user = id, user
+	c.keepAlive = time.Duration(keepAlive) * time.Second
+	c.nc.SetReadDeadline(time.Time{})
+	return c.connack(codeAccepted)
+}
+
+// connack reply CONNECT, sessions are never present
+func (c *conn) connack(code byte) error {
+	return c.write(&packet{typ: pktConnack, body: []byte{0, code}})
+}
+
+// next read and handle next packet, hub messages of it are queued
+func (c *conn) next() error {
+	if c.keepAlive > 0 {
+		// clients may be late by half of keep alive
+		c.nc.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+	}
+	p, err := readPacket(c.r)
+	if err != nil {
+		return err
+	}
+	return c.handle(p)
+}
+
+// queue message for hub, sent by this client
+func (c *conn) queue(typ, room, data string) {
+	if err := c.Add(typ, c.user, room, data); err != nil {
+		// no way to tell devices, the log helps debugging them
+		log.Debug("mqtt publish dropped", "id", c.id, "room", room, "size", len(data), "err", err)
+	}
+}
+
+// handle packet of client
+func (c *conn) handle(p *packet) error {
+	switch p.typ {
+	case pktPublish:
+		return c.publish(p)
+	case pktPuback:
+		return c.ack(p)
+	case pktSubscribe:
+		return c.subscribe(p)
+	case pktUnsubscribe:
+		return c.unsubscribe(p)
+	case pktPingreq:
+		return c.write(&packet{typ: pktPingresp})
+	case pktDisconnect:
+		return io.EOF
+	}
+	return errProtocol
+}
+
+func (c *conn) publish(p *packet) error {
+	qos := p.flags >> 1 & 0x03
+	if qos > 1 {
+		return errQoS2
+	}
+	rd := &reader{buf: p.body}
+	topic := rd.string()
+	var id uint16
+	if qos == 1 {
+		id = rd.uint16()
+	}
+	if rd.err != nil {
+		return rd.err
+	}
+	if strings.ContainsAny(topic, "+#") {
+		// wildcards are for filters only
+		return errProtocol
+	}
+
+	if rooms := c.match(topic); len(rooms) != 1 {
+		log.Debug("publish to unknown topic ignored", "id", c.id, "topic", topic)
+	} else if data := payloadText(rd.buf); data != "" {
+		c.queue(chat.T_MESSAGE, rooms[0].ID, data)
+	}
+	if qos == 1 {
+		return c.write(&packet{typ: pktPuback, body: appendUint16(nil, id)})
+	}
+	return nil
+}
+
+// payloadText return text of payload, payloads are text,
+// or json messages like the ones delivered to subscribers.
+func payloadText(payload []byte) string {
+	if len(payload) > 0 && payload[0] == '{' {
+		var msg chat.Message
+		if err := json.Unmarshal(payload, &msg); err == nil && msg.Data != "" {
+			return msg.Data
+		}
+	}
+	return string(payload)
+}
+
+func (c *conn) subscribe(p *packet) error {
+	if p.flags != 0x02 {
+		return errMalformed
+	}
+	rd := &reader{buf: p.body}
+	id := rd.uint16()
+	var codes []byte
+	for rd.err == nil && len(rd.buf) > 0 {
+		filter, qos := rd.string(), rd.byte()
+		if qos > 1 {
+			qos = 1
+		}
+		rooms := c.match(filter)
+		if len(rooms) == 0 {
+			codes = append(codes, 0x80)
+			continue
+		}
+		codes = append(codes, qos)
+
+		for _, rm := range rooms {
+			topic := filter
+			if strings.ContainsAny(filter, "+#") {
+				topic = TopicPrefix + rm.Slug
+			}
+			c.lck.Lock()
+			_, joined := c.subs[rm.ID]
+			c.subs[rm.ID] = subscription{topic: topic, qos: qos}
+			c.lck.Unlock()
+			if !joined {
+				c.queue(chat.T_JOIN, rm.ID, "")
+			}
+		}
+	}
+	if rd.err != nil || len(codes) == 0 {
+		return errMalformed
+	}
+	return c.write(&packet{typ: pktSuback, body: append(appendUint16(nil, id), codes...)})
+}
+
+// match return rooms matching topic filter, wildcards match rooms
+// existing now, rooms created later need subscribing again.
+func (c *conn) match(filter string) []*chat.RoomInfo {
+	if !strings.ContainsAny(filter, "+#") {
+		room := roomOf(filter)
+		if room == "" {
+			return nil
+		}
+		if rm := c.srv.hub.GetRoom(room); rm != nil {
+			return []*chat.RoomInfo{rm}
+		}
+		return nil
+	}
+	var rooms []*chat.RoomInfo
+	for _, rm := range c.srv.hub.RoomList() {
+		if matchTopic(filter, TopicPrefix+rm.Slug) {
+			rooms = append(rooms, rm)
+		}
+	}
+	return rooms
+}
+
+func (c *conn) unsubscribe(p *packet) error {
+	if p.flags != 0x02 {
+		return errMalformed
+	}
+	rd := &reader{buf: p.body}
+	id := rd.uint16()
+	for rd.err == nil && len(rd.buf) > 0 {
+		filter := rd.string()
+		c.lck.Lock()
+		for roomID, sub := range c.subs {
+			if sub.topic == filter || matchTopic(filter, sub.topic) {
+				delete(c.subs, roomID)
+				c.queue(chat.T_LEAVE, roomID, "")
+			}
+		}
+		c.lck.Unlock()
+	}
+	if rd.err != nil {
+		return rd.err
+	}
+	return c.write(&packet{typ: pktUnsuback, body: appendUint16(nil, id)})
+}
+
+// matchTopic check if topic matches filter with wildcards + and #
+func matchTopic(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
+
+// WriteMessage publish room messages to subscribed topic
+func (c *conn) WriteMessage(msg *chat.Message) error {
+	switch msg.Type {
+	case chat.T_MESSAGE, chat.T_SYSTEM:
+		c.lck.Lock()
+		sub, ok := c.subs[msg.Room]
+		c.lck.Unlock()
+		if !ok {
+			return nil
+		}
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if err := c.deliver(sub, payload); err != nil {
+			if gateway.Closed(err) {
+				return io.EOF
+			}
+			return err
+		}
+
+	case chat.T_CLOSE:
+		c.lck.Lock()
+		delete(c.subs, msg.Room)
+		c.lck.Unlock()
+
+	case chat.T_ERROR:
+		// no way to tell devices, the log helps debugging them
+		log.Debug("mqtt request failed", "id", c.id, "room", msg.Room, "err", msg.Data)
+	}
+	return nil
+}
+
+// deliver PUBLISH with QoS of subscription,
+// QoS 1 ones are kept until acknowledged.
+func (c *conn) deliver(sub subscription, payload []byte) error {
+	c.wlck.Lock()
+	defer c.wlck.Unlock()
+
+	body := appendString(nil, sub.topic)
+	if sub.qos == 0 {
+		return c.writeLocked(&packet{typ: pktPublish, body: append(body, payload...)})
+	}
+
+	if len(c.inflight) >= maxInflight {
+		return errInflight
+	}
+	for {
+		if c.nextID++; c.nextID != 0 && c.inflightAt(c.nextID) < 0 {
+			break
+		}
+	}
+	p := &packet{typ: pktPublish, flags: 1 << 1, body: append(appendUint16(body, c.nextID), payload...)}
+	c.inflight = append(c.inflight, &inflight{id: c.nextID, p: p, sent: time.Now()})
+	return c.writeLocked(p)
+}
+
+// inflightAt return index of in-flight publish id, -1 if not found
+func (c *conn) inflightAt(id uint16) int {
+	for i, f := range c.inflight {
+		if f.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ack forget publish acknowledged by PUBACK
+func (c *conn) ack(p *packet) error {
+	rd := &reader{buf: p.body}
+	id := rd.uint16()
+	if rd.err != nil {
+		return rd.err
+	}
+	c.wlck.Lock()
+	defer c.wlck.Unlock()
+	if i := c.inflightAt(id); i >= 0 {
+		c.inflight = append(c.inflight[:i], c.inflight[i+1:]...)
+	}
+	return nil
+}
+
+// Ping resend QoS 1 publishes not acknowledged within write wait, in the
+// order they were sent, with DUP set. Clients keep connections alive by
+// keep alive themselves.
+func (c *conn) Ping() error {
+	c.wlck.Lock()
+	defer c.wlck.Unlock()
+	for _, f := range c.inflight {
+		if time.Since(f.sent) < c.opts.WriteWait {
+			continue
+		}
+		f.p.flags |= 0x08
+		f.sent = time.Now()
+		if err := c.writeLocked(f.p); err != nil {
+			if gateway.Closed(err) {
+				return io.EOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conn) Close() error {
+	c.once.Do(func() {
+		c.srv.remove(c)
+	})
+	return c.nc.Close()
+}
+
+func (c *conn) RemoteAddr() string {
+	return c.nc.RemoteAddr().String()
+}
@@ -0,0 +1,101 @@
+// Package mqtt is an embedded MQTT 3.1.1 broker bridging topics to rooms,
+// so devices speaking only MQTT share rooms with browser users. Publishing
+// to "sparrow/rooms/<room>" broadcasts into the room, subscribing delivers
+// messages of the room. QoS 0 and 1 are supported, QoS 1 publishes are
+// resent until acknowledged. Sessions end with connections, so clients
+// asking for persistent sessions are refused, retained messages are not
+// stored.
+package mqtt
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"../chat"
+	"../gateway"
+	"../logging"
+)
+
+// TopicPrefix of room topics, followed by room id, name or slug
+const TopicPrefix = "sparrow/rooms/"
+
+var log = logging.Logger(logging.MQTT)
+
+// Options of the MQTT broker
+type Options struct {
+	Addr     string `toml:"addr" yaml:"addr"`         // listen address, e.g. :1883, disabled if empty
+	Password string `toml:"password" yaml:"password"` // password devices must send, any if empty
+}
+
+// ErrServerClosed returned by Serve after Close
+var ErrServerClosed = gateway.ErrServerClosed
+
+// Server accepts MQTT connections and serves them as clients of hub
+type Server struct {
+	*gateway.Listener
+	hub      *chat.RoomHub
+	password string
+
+	lck     sync.Mutex
+	clients map[string]*conn // connected clients by client id
+}
+
+// NewServer create MQTT broker of hub
+func NewServer(hub *chat.RoomHub, opts Options) *Server {
+	s := &Server{
+		hub:      hub,
+		password: opts.Password,
+		clients:  make(map[string]*conn),
+	}
+	s.Listener = gateway.NewListener("mqtt broker", log, s.serveConn)
+	return s
+}
+
+// serveConn accept CONNECT, then serve the connection as a hub client
+func (s *Server) serveConn(nc net.Conn) {
+	c := newConn(s, nc)
+	if err := c.connect(); err != nil {
+		log.Debug("mqtt connect failed", "addr", c.RemoteAddr(), "err", err)
+		nc.Close()
+		return
+	}
+
+	// a new connection with the same client id takes over
+	s.lck.Lock()
+	old := s.clients[c.id]
+	s.clients[c.id] = c
+	s.lck.Unlock()
+	if old != nil {
+		log.Debug("mqtt client taken over", "id", c.id, "addr", old.RemoteAddr())
+		old.Close()
+	}
+
+	client, err := s.hub.Connect(c)
+	if err != nil {
+		log.Debug("mqtt client rejected", "id", c.id, "addr", c.RemoteAddr(), "err", err)
+		return
+	}
+	log.Debug("mqtt client connected", "client", client.ID(), "id", c.id, "user", c.user, "addr", c.RemoteAddr())
+}
+
+// remove client if it's still the one of its id
+func (s *Server) remove(c *conn) {
+	s.lck.Lock()
+	if s.clients[c.id] == c {
+		delete(s.clients, c.id)
+	}
+	s.lck.Unlock()
+}
+
+// roomOf return room part of topic, empty if it's not a room topic
+func roomOf(topic string) string {
+	if !strings.HasPrefix(topic, TopicPrefix) {
+		return ""
+	}
+	room := strings.TrimPrefix(topic, TopicPrefix)
+	if strings.ContainsAny(room, "/+#") {
+		return ""
+	}
+	return room
+}
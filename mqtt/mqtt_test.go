@@ -0,0 +1,253 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"../chat"
+	"../gateway/gatewaytest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type client struct {
+	*gatewaytest.Client
+}
+
+func dial(t *testing.T, addr string) *client {
+	return &client{gatewaytest.Dial(t, addr, "")}
+}
+
+func (c *client) send(typ, flags byte, body []byte) {
+	_, err := c.Conn.Write((&packet{typ: typ, flags: flags, body: body}).encode())
+	assert.NoError(c.T, err)
+}
+
+// expect read next packet of typ, room publishes are skipped unless expected
+func (c *client) expect(typ byte) *packet {
+	c.Conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		p, err := readPacket(c.R)
+		if !assert.NoError(c.T, err) {
+			c.T.FailNow()
+		}
+		if p.typ == pktPublish && typ != pktPublish {
+			continue
+		}
+		if !assert.Equal(c.T, typ, p.typ) {
+			c.T.FailNow()
+		}
+		return p
+	}
+}
+
+// connect send CONNECT with clean session and return the CONNACK code
+func (c *client) connect(id, user, password string) byte {
+	return c.connectFlags(id, user, password, 0x02)
+}
+
+func (c *client) connectFlags(id, user, password string, flags byte) byte {
+	body := appendString(nil, "MQTT")
+	if user != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+	body = append(body, 4, flags)
+	body = appendUint16(body, 60)
+	body = appendString(body, id)
+	if user != "" {
+		body = appendString(body, user)
+	}
+	if password != "" {
+		body = appendString(body, password)
+	}
+	c.send(pktConnect, 0, body)
+	return c.expect(pktConnack).body[1]
+}
+
+func (c *client) subscribe(id uint16, filter string, qos byte) []byte {
+	body := append(appendString(appendUint16(nil, id), filter), qos)
+	c.send(pktSubscribe, 0x02, body)
+	p := c.expect(pktSuback)
+	assert.Equal(c.T, id, (&reader{buf: p.body}).uint16())
+	return p.body[2:]
+}
+
+func TestMatchTopic(t *testing.T) {
+	assert.True(t, matchTopic("sparrow/rooms/+", "sparrow/rooms/general"))
+	assert.True(t, matchTopic("sparrow/#", "sparrow/rooms/general"))
+	assert.True(t, matchTopic("#", "sparrow/rooms/general"))
+	assert.False(t, matchTopic("sparrow/+", "sparrow/rooms/general"))
+	assert.False(t, matchTopic("sparrow/rooms/+/x", "sparrow/rooms/general"))
+	assert.Equal(t, "general", roomOf("sparrow/rooms/general"))
+	assert.Equal(t, "", roomOf("sparrow/rooms/a/b"))
+	assert.Equal(t, "", roomOf("rooms/general"))
+	assert.Equal(t, "hi", payloadText([]byte(`{"data":"hi"}`)))
+	assert.Equal(t, "{hi", payloadText([]byte(`{hi`)))
+}
+
+func TestBroker(t *testing.T) {
+	hub := chat.NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := NewServer(hub, Options{Password: "secret"})
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// web user
+	local, web := chat.Pipe()
+	hub.Connect(local)
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_JOIN, From: "web", Room: "general"}))
+
+	bad := dial(t, ln.Addr().String())
+	assert.Equal(t, byte(codeBadCredentials), bad.connect("sensor", "", "wrong"))
+	bad.Conn.Close()
+
+	c := dial(t, ln.Addr().String())
+	defer c.Conn.Close()
+	assert.Equal(t, byte(codeAccepted), c.connect("sensor", "thermo", "secret"))
+	assert.Equal(t, []byte{1}, c.subscribe(1, TopicPrefix+"general", 1))
+	assert.Equal(t, []byte{0x80}, c.subscribe(2, TopicPrefix+"nope", 0))
+
+	c.send(pktPingreq, 0, nil)
+	c.expect(pktPingresp)
+
+	// mqtt to web, too long payload is dropped
+	c.send(pktPublish, 0, append(appendString(nil, TopicPrefix+"general"), strings.Repeat("x", 600)...))
+	body := appendUint16(appendString(nil, TopicPrefix+"general"), 7)
+	c.send(pktPublish, 1<<1, append(body, "23℃"...))
+	assert.Equal(t, uint16(7), (&reader{buf: c.expect(pktPuback).body}).uint16())
+	for {
+		msg, err := web.ReadMessage()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if msg.Type == chat.T_MESSAGE {
+			assert.Equal(t, "thermo", msg.From)
+			assert.Equal(t, rm.ID, msg.Room)
+			assert.Equal(t, "23℃", msg.Data)
+			break
+		}
+	}
+
+	// the subscriber gets its own publish too, then web messages
+	go func() {
+		for {
+			if _, err := web.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_MESSAGE, From: "web", Room: rm.ID, Data: "hello"}))
+	var got []string
+	for len(got) < 2 {
+		p := c.expect(pktPublish)
+		assert.Equal(t, byte(1<<1), p.flags)
+		rd := &reader{buf: p.body}
+		assert.Equal(t, TopicPrefix+"general", rd.string())
+		c.send(pktPuback, 0, appendUint16(nil, rd.uint16()))
+		var msg chat.Message
+		assert.NoError(t, json.Unmarshal(rd.buf, &msg))
+		if msg.Type == chat.T_MESSAGE {
+			got = append(got, msg.From+": "+msg.Data)
+		}
+	}
+	assert.Equal(t, []string{"thermo: 23℃", "web: hello"}, got)
+
+	// same client id takes over
+	c2 := dial(t, ln.Addr().String())
+	defer c2.Conn.Close()
+	assert.Equal(t, byte(codeAccepted), c2.connect("sensor", "", "secret"))
+	c.Conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for err == nil {
+		_, err = readPacket(c.R)
+	}
+	assert.NotErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	assert.Equal(t, []byte{0}, c2.subscribe(3, TopicPrefix+"+", 0))
+	c2.send(pktUnsubscribe, 0x02, appendString(appendUint16(nil, 4), TopicPrefix+"+"))
+	c2.expect(pktUnsuback)
+	c2.send(pktDisconnect, 0, nil)
+	web.Close()
+	assert.Eventually(t, func() bool {
+		return len(hub.Clients()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRedeliver(t *testing.T) {
+	hub := chat.NewChatHub()
+	opts := chat.DefaultOptions
+	opts.PongWait = 200 * time.Millisecond
+	opts.WriteWait = 100 * time.Millisecond
+	hub.SetOptions(opts)
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := NewServer(hub, Options{})
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	c := dial(t, ln.Addr().String())
+	defer c.Conn.Close()
+	assert.Equal(t, byte(codeBadIdentifier), c.connectFlags("sensor", "", "", 0))
+	c = dial(t, ln.Addr().String())
+	defer c.Conn.Close()
+	assert.Equal(t, byte(codeAccepted), c.connect("sensor", "", ""))
+	assert.Equal(t, []byte{1}, c.subscribe(1, TopicPrefix+"general", 1))
+
+	local, web := chat.Pipe()
+	hub.Connect(local)
+	go func() {
+		for {
+			if _, err := web.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	defer web.Close()
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_JOIN, From: "web", Room: rm.ID}))
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_MESSAGE, From: "web", Room: rm.ID, Data: "hello"}))
+
+	// publish of message is not acknowledged, others are
+	publish := func() (*packet, uint16, chat.Message) {
+		p := c.expect(pktPublish)
+		rd := &reader{buf: p.body}
+		rd.string()
+		id := rd.uint16()
+		var msg chat.Message
+		assert.NoError(t, json.Unmarshal(rd.buf, &msg))
+		return p, id, msg
+	}
+	var dropped uint16
+	for dropped == 0 {
+		p, id, msg := publish()
+		assert.Equal(t, byte(1<<1), p.flags)
+		if msg.Type == chat.T_MESSAGE {
+			dropped = id
+		} else {
+			c.send(pktPuback, 0, appendUint16(nil, id))
+		}
+	}
+
+	p, id, msg := publish()
+	assert.Equal(t, byte(1<<1|0x08), p.flags, "DUP is set")
+	assert.Equal(t, dropped, id)
+	assert.Equal(t, "hello", msg.Data)
+	c.send(pktPuback, 0, appendUint16(nil, id))
+
+	// nothing left to resend
+	c.Conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = readPacket(c.R)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
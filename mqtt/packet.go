@@ -0,0 +1,161 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// packet types, the high 4 bits of fixed header
+const (
+	pktConnect     = 1
+	pktConnack     = 2
+	pktPublish     = 3
+	pktPuback      = 4
+	pktPubrec      = 5
+	pktPubrel      = 6
+	pktPubcomp     = 7
+	pktSubscribe   = 8
+	pktSuback      = 9
+	pktUnsubscribe = 10
+	pktUnsuback    = 11
+	pktPingreq     = 12
+	pktPingresp    = 13
+	pktDisconnect  = 14
+)
+
+// CONNACK return codes
+const (
+	codeAccepted       = 0
+	codeBadProtocol    = 1
+	codeBadIdentifier  = 2
+	codeUnavailable    = 3
+	codeBadCredentials = 4
+	codeNotAuthorized  = 5
+)
+
+// maxPacket is the largest packet accepted from clients
+const maxPacket = 64 << 10
+
+var (
+	errMalformed = errors.New("mqtt: malformed packet")
+	errTooLarge  = errors.New("mqtt: packet too large")
+)
+
+// packet is a control packet, flags are the low 4 bits of fixed header
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// readPacket read next control packet
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// remaining length, 7 bits a byte, at most 4 bytes
+	var n, shift uint
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, errMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		n |= uint(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	if n > maxPacket {
+		return nil, errTooLarge
+	}
+
+	p := &packet{typ: header >> 4, flags: header & 0x0f, body: make([]byte, n)}
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, unexpected(err)
+	}
+	return p, nil
+}
+
+// unexpected turn EOF inside a packet into ErrUnexpectedEOF
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// encode packet with fixed header
+func (p *packet) encode() []byte {
+	buf := make([]byte, 0, len(p.body)+5)
+	buf = append(buf, p.typ<<4|p.flags)
+	n := len(p.body)
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(buf, p.body...)
+}
+
+// reader of packet body fields
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.buf) < 1 {
+		r.err = errMalformed
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) uint16() uint16 {
+	if r.err != nil || len(r.buf) < 2 {
+		r.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.buf)
+	r.buf = r.buf[2:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := int(r.uint16())
+	if r.err != nil || len(r.buf) < n {
+		r.err = errMalformed
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(appendUint16(buf, uint16(len(s))), s...)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"./chat"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// PageData is the data model of served pages, pages get it as template
+// data, static clients get it from /config.json.
+type PageData struct {
+	Name        string          `json:"name"`         // server name
+	URL         string          `json:"url"`          // http url of the server
+	WSURL       string          `json:"ws_url"`       // websocket url to connect
+	DefaultRoom string          `json:"default_room"` // slug of the room joined by default
+	Rooms       []string        `json:"rooms"`        // slugs of rooms created by config
+	Features    map[string]bool `json:"features"`     // feature flags
+}
+
+// Site serves pages with settings of current config
+type Site struct {
+	lck sync.RWMutex
+	cfg *Config
+}
+
+// NewSite create site of given config
+func NewSite(cfg *Config) *Site {
+	return &Site{cfg: cfg}
+}
+
+// SetConfig replace config of the site, used when config is reloaded
+func (s *Site) SetConfig(cfg *Config) {
+	s.lck.Lock()
+	s.cfg = cfg
+	s.lck.Unlock()
+}
+
+// Config return current config of the site
+func (s *Site) Config() *Config {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+	return s.cfg
+}
+
+// PageData return page data for the request, urls are derived from the
+// request unless public url is configured, so LAN clients connect back
+// to the address they opened the page with.
+func (s *Site) PageData(r *http.Request) *PageData {
+	cfg := s.Config()
+	base := baseURL(cfg.PublicURL, cfg.TrustProxy, r)
+
+	ws := *base
+	ws.Scheme = "ws"
+	if base.Scheme == "https" {
+		ws.Scheme = "wss"
+	}
+	ws.Path = path.Join(base.Path, "/ws")
+
+	data := &PageData{
+		Name:     cfg.Name,
+		URL:      base.String(),
+		WSURL:    ws.String(),
+		Features: features(cfg),
+	}
+	for _, room := range cfg.Rooms {
+		data.Rooms = append(data.Rooms, chat.Slugify(room))
+	}
+	if len(data.Rooms) > 0 {
+		data.DefaultRoom = data.Rooms[0]
+	}
+	return data
+}
+
+// ConfigHandle serve page data as json
+func (s *Site) ConfigHandle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(s.PageData(r))
+}
+
+// baseURL return the configured public url, or the url of request,
+// forwarded headers are honored only if the proxy setting them is trusted,
+// otherwise any client could point join links at another host.
+func baseURL(public string, trustProxy bool, r *http.Request) *url.URL {
+	if public != "" {
+		if u, err := url.Parse(public); err == nil {
+			u.Path = strings.TrimSuffix(u.Path, "/")
+			return u
+		}
+	}
+
+	u := &url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	// behind reverse proxy
+	if trustProxy {
+		if proto := forwarded(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			u.Scheme = proto
+		}
+		if host := forwarded(r, "X-Forwarded-Host"); host != "" {
+			u.Host = host
+		}
+	}
+	if u.Host == "" {
+		u.Host = "localhost"
+	}
+	// drop default port of scheme
+	if h, port, err := net.SplitHostPort(u.Host); err == nil &&
+		(u.Scheme == "http" && port == "80" || u.Scheme == "https" && port == "443") {
+		u.Host = h
+		if strings.Contains(h, ":") {
+			u.Host = "[" + h + "]"
+		}
+	}
+	return u
+}
+
+// forwarded return the first value of a forwarded header
+func forwarded(r *http.Request, key string) string {
+	v := r.Header.Get(key)
+	if i := strings.Index(v, ","); i >= 0 {
+		v = v[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+// features derived from config, overridden by configured flags
+func features(cfg *Config) map[string]bool {
+	fs := map[string]bool{
+		"tls":        cfg.TLS.Auto || cfg.TLS.Cert != "",
+		"rate_limit": cfg.Limits.MessageRate > 0,
+		"dev":        cfg.Dev,
+	}
+	for k, v := range cfg.Features {
+		fs[k] = v
+	}
+	return fs
+}
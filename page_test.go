@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageData(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Rooms = []string{"大厅", "Dev Talk"}
+	site := NewSite(cfg)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "192.168.1.10:9090"
+	data := site.PageData(r)
+	assert.Equal(t, "ws://192.168.1.10:9090/ws", data.WSURL)
+	assert.Equal(t, "http://192.168.1.10:9090", data.URL)
+	assert.Equal(t, "大厅", data.DefaultRoom)
+	assert.Equal(t, []string{"大厅", "dev-talk"}, data.Rooms)
+	assert.False(t, data.Features["tls"])
+
+	// tls and default port
+	r.Host = "chat.lan:443"
+	r.TLS = &tls.ConnectionState{}
+	assert.Equal(t, "wss://chat.lan/ws", site.PageData(r).WSURL)
+
+	// forwarded headers are ignored unless proxy is trusted
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "chat.example.com")
+	assert.Equal(t, "ws://example.com/ws", site.PageData(r).WSURL)
+
+	// behind reverse proxy
+	cfg.TrustProxy = true
+	site.SetConfig(cfg)
+	assert.Equal(t, "wss://chat.example.com/ws", site.PageData(r).WSURL)
+
+	// configured public url wins
+	cfg = DefaultConfig()
+	cfg.PublicURL = "https://example.com/sparrow/"
+	cfg.Features = map[string]bool{"tls": true, "voice": false}
+	site.SetConfig(cfg)
+	data = site.PageData(r)
+	assert.Equal(t, "wss://example.com/sparrow/ws", data.WSURL)
+	assert.Equal(t, "https://example.com/sparrow", data.URL)
+	assert.Equal(t, map[string]bool{"tls": true, "voice": false, "rate_limit": true, "dev": false}, data.Features)
+}
+
+func TestConfigHandle(t *testing.T) {
+	site := NewSite(DefaultConfig())
+	w := httptest.NewRecorder()
+	site.ConfigHandle(w, httptest.NewRequest("GET", "http://localhost:9090/config.json", nil))
+
+	var data PageData
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+	assert.Equal(t, "ws://localhost:9090/ws", data.WSURL)
+	assert.Equal(t, "私密聊天室", data.Name)
+}
+
+func TestIndexHandle(t *testing.T) {
+	dir := t.TempDir()
+	page := `<script id="page-data" type="application/json">{{.}}</script>`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(page), 0644))
+
+	assets, err := NewAssets(dir)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	IndexHandle(assets, NewSite(DefaultConfig()))(w, httptest.NewRequest("GET", "http://localhost:9090/", nil))
+
+	body := w.Body.String()
+	body = strings.TrimSuffix(strings.TrimPrefix(body, `<script id="page-data" type="application/json">`), `</script>`)
+	var data PageData
+	assert.NoError(t, json.Unmarshal([]byte(body), &data), body)
+	assert.Equal(t, "ws://localhost:9090/ws", data.WSURL)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	qrDefaultSize = 256
+	qrMaxSize     = 1024
+)
+
+// JoinURL return deep link to the chat page at base url, which optionally
+// preselects a room and carries an invite token.
+func JoinURL(base, room, invite string) string {
+	q := url.Values{}
+	if room != "" {
+		q.Set("room", room)
+	}
+	if invite != "" {
+		q.Set("invite", invite)
+	}
+
+	link := strings.TrimSuffix(base, "/") + "/"
+	if len(q) > 0 {
+		link += "?" + q.Encode()
+	}
+	return link
+}
+
+// lanURLs return urls of the server for each LAN address
+func lanURLs(cfg *Config) []string {
+	if cfg.PublicURL != "" {
+		return []string{cfg.PublicURL}
+	}
+
+	scheme := "http"
+	if cfg.TLS.Auto || cfg.TLS.Cert != "" {
+		scheme = "https"
+	}
+	_, port, _ := net.SplitHostPort(cfg.Addr)
+
+	var urls []string
+	for _, ip := range GetLocalIPAddr() {
+		host := ip
+		if port != "" && !(scheme == "http" && port == "80" || scheme == "https" && port == "443") {
+			host = net.JoinHostPort(ip, port)
+		}
+		urls = append(urls, scheme+"://"+host)
+	}
+	return urls
+}
+
+// printQRCodes print QR code of join link for each LAN address,
+// phones on the LAN join by scanning them.
+func printQRCodes(cfg *Config) {
+	for _, base := range lanURLs(cfg) {
+		link := JoinURL(base, "", "")
+		qr, err := qrcode.New(link, qrcode.Low)
+		if err != nil {
+			mainLog.Error("create QR code failed", "url", link, "err", err)
+			continue
+		}
+		fmt.Println(link)
+		fmt.Println(qr.ToSmallString(false))
+	}
+}
+
+// QRHandle serve QR code png of join link, query parameters room and
+// invite are carried by the link, size is the image size in pixels.
+func (s *Site) QRHandle(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	size := qrDefaultSize
+	if v := q.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 64 || n > qrMaxSize {
+			http.Error(w, fmt.Sprintf("size must be 64 to %d", qrMaxSize), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	link := JoinURL(s.PageData(r).URL, q.Get("room"), q.Get("invite"))
+	png, err := qrcode.Encode(link, qrcode.Medium, size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Join-URL", link)
+	w.Write(png)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinURL(t *testing.T) {
+	assert.Equal(t, "http://192.168.1.10:9090/", JoinURL("http://192.168.1.10:9090", "", ""))
+	assert.Equal(t, "https://chat.lan/sparrow/?room=%E5%A4%A7%E5%8E%85", JoinURL("https://chat.lan/sparrow/", "大厅", ""))
+	assert.Equal(t, "http://h/?invite=x+y&room=dev", JoinURL("http://h", "dev", "x y"))
+}
+
+func TestQRHandle(t *testing.T) {
+	site := NewSite(DefaultConfig())
+
+	w := httptest.NewRecorder()
+	site.QRHandle(w, httptest.NewRequest("GET", "http://192.168.1.10:9090/qr.png?room=dev&size=128", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, "http://192.168.1.10:9090/?room=dev", w.Header().Get("X-Join-URL"))
+
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 128, img.Bounds().Dx())
+
+	w = httptest.NewRecorder()
+	site.QRHandle(w, httptest.NewRequest("GET", "/qr.png?size=99999", nil))
+	assert.Equal(t, 400, w.Code)
+}
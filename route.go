@@ -1,22 +1,28 @@
 package main
 
 import (
+	"./std"
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
-	"path/filepath"
+	"runtime/debug"
 	"strings"
 	"time"
 
-	"github.com/go-clog/clog"
 	"github.com/gorilla/mux"
 )
 
-// ResponseLogger is a middleware used to keep an copy of Response.StatusCode.
+// ResponseLogger is a middleware used to keep an copy of Response.StatusCode
+// and count bytes written.
 //
 type ResponseLogger struct {
 	w          http.ResponseWriter
 	StatusCode int
+	Bytes      int64
 }
 
 // Header returns the header map that will be sent by
@@ -28,7 +34,12 @@ func (m *ResponseLogger) Header() http.Header {
 
 // Write writes the data to the connection as part of an HTTP reply.
 func (m *ResponseLogger) Write(data []byte) (int, error) {
-	return m.w.Write(data)
+	if m.StatusCode == 0 {
+		m.StatusCode = http.StatusOK
+	}
+	n, err := m.w.Write(data)
+	m.Bytes += int64(n)
+	return n, err
 }
 
 // WriteHeader sends an HTTP response header with status code.
@@ -44,65 +55,155 @@ func (m *ResponseLogger) WriteHeader(status int) {
 	m.w.WriteHeader(status)
 }
 
-// IndexHandle for index page
-//
-func IndexHandle(w http.ResponseWriter, r *http.Request) {
-	root, _ := filepath.Abs("./public")
-	index := filepath.Join(root, "index.html")
+// Flush sends any buffered data to the client, used by streaming responses.
+func (m *ResponseLogger) Flush() {
+	if f, ok := m.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, used by websocket.
+func (m *ResponseLogger) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := m.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	if m.StatusCode == 0 {
+		m.StatusCode = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
 
-	if tmpl, err := template.ParseFiles(index); err == nil {
-		tmpl.Execute(w, nil)
-	} else {
+// Unwrap return the original response writer, used by http.ResponseController.
+func (m *ResponseLogger) Unwrap() http.ResponseWriter {
+	return m.w
+}
+
+// IndexHandle for index page of assets, executed with page data
+//
+func IndexHandle(assets *Assets, site *Site) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, err := assets.ReadFile("index.html")
+		if err == nil {
+			var tmpl *template.Template
+			if tmpl, err = template.New("index").Parse(string(page)); err == nil {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				tmpl.Execute(w, site.PageData(r))
+				return
+			}
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "%v", err)
 	}
 }
 
-// StaticHandler serve public files, exclude folder
+// StaticHandler serve files of assets under /public/, exclude folder
 //
-func StaticHandler() http.Handler {
-	root, _ := filepath.Abs("./public")
+func StaticHandler(assets *Assets) http.Handler {
+	files := http.StripPrefix("/public/", assets)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// if request folder, return not found
-		if strings.TrimRight(r.RequestURI, "/") != r.RequestURI {
-			clog.Warn("[Res] Access (%s) forbidden.", r.RequestURI)
+		if strings.HasSuffix(r.URL.Path, "/") {
+			httpLog.Warn("access folder forbidden", "request_id", RequestID(r), "path", r.URL.Path)
 			http.NotFound(w, r)
-		} else {
-			clog.Info("[Res] Access %s", r.RequestURI)
-			http.FileServer(http.Dir(root)).ServeHTTP(w, r)
+			return
 		}
+		files.ServeHTTP(w, r)
 	})
 }
 
-// LogHandler print request trace log
+// LogHandler write access log of requests
 //
-func LogHandler(h http.Handler, name string) http.Handler {
+func LogHandler(h http.Handler, log *AccessLog) http.Handler {
 	return http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		w := &ResponseLogger{w: resp}
 		h.ServeHTTP(w, r)
+		log.Log(r, w.StatusCode, w.Bytes, time.Since(start))
+	})
+}
+
+type ctxKey int
+
+const requestIDKey ctxKey = iota
+
+// RequestID return id of the request set by RequestIDHandler
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
 
-		// "GET / HTTP/1.1" 200 2552 UserAgent
-		clog.Info("[API] %s - %d %s %s %s - %s",
-			r.RemoteAddr,
-			w.StatusCode,
-			r.Proto,
-			r.Method,
-			r.RequestURI,
-			time.Since(start))
+// RequestIDHandler tag each request with an unique id, the X-Request-ID
+// header from proxies is kept, the id is sent back in the same header.
+//
+func RequestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = std.GenUIDs()
+		}
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RecoverHandler recover panics of handlers, log them with stack and
+// reply 500 if nothing has been written yet.
+//
+func RecoverHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
+		w, ok := resp.(*ResponseLogger)
+		if !ok {
+			w = &ResponseLogger{w: resp}
+		}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				// aborted on purpose
+				panic(err)
+			}
+			httpLog.Error("panic serving request", "request_id", RequestID(r),
+				"method", r.Method, "path", r.URL.Path, "err", err, "stack", string(debug.Stack()))
+			if w.StatusCode == 0 {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(w, r)
 	})
 }
 
 // NewRouter return the registered router
 //
-func NewRouter() *mux.Router {
+func NewRouter(assets *Assets) *mux.Router {
 	router := mux.NewRouter()
 	router.StrictSlash(true)
 
 	// static files handler
 	router.
 		PathPrefix("/public/").
-		Handler(LogHandler(StaticHandler(), "log"))
+		Handler(StaticHandler(assets))
 
 	return router
 }
+
+// Handler wrap router with middlewares, request ids are assigned first,
+// so access logs and recovered panics carry them.
+//
+func Handler(router http.Handler, log *AccessLog) http.Handler {
+	return RequestIDHandler(LogHandler(RecoverHandler(router), log))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// lockedBuffer is written by server goroutines and read by tests
+type lockedBuffer struct {
+	lck sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	return []byte(b.String())
+}
+
+func testHandler(t *testing.T, format string) (http.Handler, *lockedBuffer) {
+	assets, err := NewAssets("")
+	assert.NoError(t, err)
+	r := NewRouter(assets)
+	r.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	r.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	r.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if assert.NoError(t, err) {
+			conn.WriteMessage(websocket.TextMessage, []byte("hi"))
+			conn.Close()
+		}
+	})
+
+	buf := &lockedBuffer{}
+	log, err := NewAccessLog(format, buf)
+	assert.NoError(t, err)
+	return Handler(r, log), buf
+}
+
+func TestAccessLogCommon(t *testing.T) {
+	h, buf := testHandler(t, LogCombined)
+
+	r := httptest.NewRequest("GET", "/hello?x=1", nil)
+	r.Header.Set("X-Request-ID", "req-1")
+	r.Header.Set("User-Agent", "curl/8")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, "req-1", w.Header().Get("X-Request-ID"))
+	line := buf.String()
+	assert.True(t, strings.HasPrefix(line, "192.0.2.1 - - ["), line)
+	assert.Contains(t, line, `"GET /hello?x=1 HTTP/1.1" 200 5 "" "curl/8" `)
+	assert.True(t, strings.HasSuffix(line, " req-1\n"), line)
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	h, buf := testHandler(t, LogJSON)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	assert.Equal(t, 500, w.Code)
+
+	var entry accessEntry
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, 500, entry.Status)
+	assert.Equal(t, "/panic", entry.URI)
+	assert.NotEmpty(t, entry.RequestID)
+	assert.Equal(t, w.Header().Get("X-Request-ID"), entry.RequestID)
+	assert.Equal(t, int64(w.Body.Len()), entry.Bytes)
+
+	_, err := NewAccessLog("apache", nil)
+	assert.Error(t, err)
+}
+
+func TestStaticHandlerFolder(t *testing.T) {
+	h, buf := testHandler(t, LogCommon)
+
+	for path, code := range map[string]int{
+		"/public/css/index.css": 200,
+		"/public/css/":          404,
+		"/public/":              404,
+		"/public/missing.css":   404,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, code, w.Code, path)
+	}
+	assert.Equal(t, 4, strings.Count(buf.String(), "\n"))
+}
+
+func TestHandlerWebsocket(t *testing.T) {
+	h, buf := testHandler(t, LogCommon)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if assert.NoError(t, err) {
+		_, data, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "hi", string(data))
+		conn.Close()
+	}
+	// hijacked requests are logged after the handler returns
+	assert.Eventually(t, func() bool {
+		return strings.Contains(buf.String(), `"GET /ws HTTP/1.1" 101 `)
+	}, time.Second, 10*time.Millisecond)
+}
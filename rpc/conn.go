@@ -0,0 +1,166 @@
+package rpc
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+
+	"../chat"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// conn is the chat.Conn of a Connect stream
+type conn struct {
+	stream Chat_ConnectServer
+	addr   string
+	done   chan struct{} // closed with conn, ends the stream
+	once   sync.Once
+
+	lck   sync.Mutex // guards sends
+	ended bool       // handler has returned, stream can't be used
+}
+
+func newConn(stream Chat_ConnectServer) *conn {
+	addr := "grpc"
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		addr = p.Addr.String()
+	}
+	return &conn{stream: stream, addr: addr, done: make(chan struct{})}
+}
+
+func (c *conn) ReadMessage() (*chat.Message, error) {
+	m, err := c.stream.Recv()
+	if err != nil {
+		if err == io.EOF || status.Code(err) == codes.Canceled {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return fromMessage(m), nil
+}
+
+func (c *conn) WriteMessage(msg *chat.Message) error {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	if c.ended {
+		return io.EOF
+	}
+	if err := c.stream.Send(toMessage(msg)); err != nil {
+		// stream has ended
+		return io.EOF
+	}
+	return nil
+}
+
+// Ping check the stream is alive, the transport pings peers itself
+func (c *conn) Ping() error {
+	select {
+	case <-c.done:
+		return chat.ErrConnClosed
+	case <-c.stream.Context().Done():
+		return io.EOF
+	default:
+		return nil
+	}
+}
+
+func (c *conn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
+
+// end the stream, called when the handler returns
+func (c *conn) end() {
+	c.lck.Lock()
+	c.ended = true
+	c.lck.Unlock()
+}
+
+func (c *conn) RemoteAddr() string {
+	return c.addr
+}
+
+// typeOf return message type of T_* value, the enum names are them
+// prefixed with "TYPE_".
+func typeOf(typ string) Type {
+	return Type(Type_value["TYPE_"+typ])
+}
+
+// fromMessage convert message of client into hub message
+func fromMessage(m *Message) *chat.Message {
+	typ := ""
+	if m.Type != Type_TYPE_UNSPECIFIED {
+		typ = strings.TrimPrefix(m.Type.String(), "TYPE_")
+	}
+	return &chat.Message{
+		ID:   m.Id,
+		Type: typ,
+		From: m.From,
+		Room: m.Room,
+		Data: m.Data,
+	}
+}
+
+// toMessage convert hub message, rooms of replies are decoded from data
+func toMessage(msg *chat.Message) *Message {
+	m := &Message{
+		Id:        msg.ID,
+		Type:      typeOf(msg.Type),
+		From:      msg.From,
+		Room:      msg.Room,
+		Timestamp: msg.Timestamp,
+		Data:      msg.Data,
+		Event:     msg.Event,
+		Meta:      msg.Meta,
+	}
+	switch msg.Type {
+	case chat.T_ROOMS:
+		var rooms []*chat.RoomInfo
+		if json.Unmarshal([]byte(msg.Data), &rooms) == nil {
+			m.Rooms = toRooms(rooms)
+		}
+	case chat.T_CREATE, chat.T_RENAME, chat.T_UPDATE:
+		var rm chat.RoomInfo
+		if json.Unmarshal([]byte(msg.Data), &rm) == nil {
+			m.Rooms = []*Room{toRoom(&rm)}
+		}
+	}
+	return m
+}
+
+func toRoom(rm *chat.RoomInfo) *Room {
+	r := &Room{
+		Id:           rm.ID,
+		Name:         rm.Name,
+		Slug:         rm.Slug,
+		Aliases:      rm.Aliases,
+		Owner:        rm.Owner,
+		Topic:        rm.Topic,
+		Desc:         rm.Desc,
+		Avatar:       rm.Avatar,
+		Pinned:       rm.Pinned,
+		Muted:        rm.Muted,
+		Active:       rm.Active,
+		ClientCount:  rm.CCount,
+		MessageCount: rm.MCount,
+	}
+	if !rm.Updated.IsZero() {
+		r.Updated = timestamppb.New(rm.Updated)
+	}
+	return r
+}
+
+func toRooms(rooms []*chat.RoomInfo) []*Room {
+	res := make([]*Room, 0, len(rooms))
+	for _, rm := range rooms {
+		res = append(res, toRoom(rm))
+	}
+	return res
+}
@@ -0,0 +1,214 @@
+// Package rpc is the gRPC api of sparrow for services, defined in
+// sparrow.proto. Connect streams are clients of the room hub like
+// websocket connections, unary rpcs manage rooms like the REST api.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sparrow.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"../chat"
+	"../logging"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+var log = logging.Logger(logging.RPC)
+
+// Options of the gRPC listener
+type Options struct {
+	Addr string `toml:"addr" yaml:"addr"` // listen address, e.g. :9092, disabled if empty
+}
+
+// ErrServerClosed returned by Serve after Close
+var ErrServerClosed = errors.New("rpc: server closed")
+
+// Server serves the Chat service of hub
+type Server struct {
+	UnimplementedChatServer
+
+	hub    *chat.RoomHub
+	tokens func() []string
+	srv    *grpc.Server
+}
+
+// NewServer create gRPC server of hub, calls need one of tokens,
+// they are read on each call so reloaded tokens apply. Extra options
+// are passed to the gRPC server, e.g. grpc.Creds to serve TLS.
+func NewServer(hub *chat.RoomHub, opts Options, tokens func() []string, extra ...grpc.ServerOption) *Server {
+	s := &Server{hub: hub, tokens: tokens}
+	chatOpts := hub.Options()
+	s.srv = grpc.NewServer(append([]grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+			if err := s.auth(ctx); err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if err := s.auth(ss.Context()); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+		// ping peers like websocket clients are pinged
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    chatOpts.PongWait,
+			Timeout: chatOpts.WriteWait,
+		}),
+		grpc.MaxRecvMsgSize(int(chatOpts.MaxMessageSize) + 4<<10),
+	}, extra...)...)
+	RegisterChatServer(s.srv, s)
+	return s
+}
+
+// ListenAndServe listen on TCP addr and serve gRPC calls
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve gRPC calls accepted by ln until Close
+func (s *Server) Serve(ln net.Listener) error {
+	log.Info("grpc server started", "addr", ln.Addr().String())
+	if err := s.srv.Serve(ln); err != nil && err != grpc.ErrServerStopped {
+		return err
+	}
+	return ErrServerClosed
+}
+
+// Close stop serving, streams and calls in progress are closed
+func (s *Server) Close() error {
+	s.srv.Stop()
+	return nil
+}
+
+// auth check bearer token of call against api tokens
+func (s *Server) auth(ctx context.Context) error {
+	tokens := s.tokens()
+	if len(tokens) == 0 {
+		return status.Error(codes.PermissionDenied, "api disabled, set api tokens to enable it")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if !strings.HasPrefix(auth, "Bearer ") {
+			continue
+		}
+		token := strings.TrimPrefix(auth, "Bearer ")
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return nil
+			}
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+// rpcError return status error with code matching err
+func rpcError(err error) error {
+	code, ok := codeOf[chat.StatusCode(err)]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+// codeOf gRPC code of HTTP status code of hub errors
+var codeOf = map[int]codes.Code{
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.AlreadyExists,
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+}
+
+// Connect serve stream as a client of hub until either side closes it
+func (s *Server) Connect(stream Chat_ConnectServer) error {
+	c := newConn(stream)
+	client, err := s.hub.Connect(c)
+	if err != nil {
+		return rpcError(err)
+	}
+	log.Debug("grpc client connected", "client", client.ID(), "addr", c.RemoteAddr())
+	<-c.done
+	c.end()
+	return nil
+}
+
+func (s *Server) ListRooms(ctx context.Context, req *ListRoomsRequest) (*ListRoomsResponse, error) {
+	return &ListRoomsResponse{Rooms: toRooms(s.hub.RoomList())}, nil
+}
+
+func (s *Server) GetRoom(ctx context.Context, req *GetRoomRequest) (*Room, error) {
+	rm := s.hub.GetRoom(req.Room)
+	if rm == nil {
+		return nil, rpcError(chat.ErrRoomNotFound)
+	}
+	return toRoom(rm), nil
+}
+
+func (s *Server) CreateRoom(ctx context.Context, req *CreateRoomRequest) (*Room, error) {
+	rm, err := s.hub.NewRoom(req.Name, req.Owner)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return toRoom(rm), nil
+}
+
+func (s *Server) DeleteRoom(ctx context.Context, req *DeleteRoomRequest) (*Room, error) {
+	rm := s.hub.DeleteRoom(req.Room)
+	if rm == nil {
+		return nil, rpcError(chat.ErrRoomNotFound)
+	}
+	return toRoom(rm), nil
+}
+
+func (s *Server) ListMessages(ctx context.Context, req *ListMessagesRequest) (*ListMessagesResponse, error) {
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = defaultLimit
+	}
+	if limit < 0 || limit > maxLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be 1 to %d", maxLimit)
+	}
+	msgs, err := s.hub.History(req.Room, req.Before, limit)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	res := &ListMessagesResponse{Messages: make([]*Message, 0, len(msgs))}
+	for _, msg := range msgs {
+		res.Messages = append(res.Messages, toMessage(msg))
+	}
+	return res, nil
+}
+
+func (s *Server) PostMessage(ctx context.Context, req *PostMessageRequest) (*Message, error) {
+	if req.From == "" {
+		req.From = "grpc"
+	}
+	msg, err := s.hub.Post(req.Room, req.From, req.Data)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return toMessage(msg), nil
+}
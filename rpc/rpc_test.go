@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"../chat"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTypes(t *testing.T) {
+	for _, typ := range []string{chat.T_JOIN, chat.T_LEAVE, chat.T_CREATE, chat.T_CLOSE, chat.T_ROOMS,
+		chat.T_RENAME, chat.T_UPDATE, chat.T_MESSAGE, chat.T_SYSTEM, chat.T_ERROR} {
+		m := toMessage(&chat.Message{Type: typ})
+		assert.NotEqual(t, Type_TYPE_UNSPECIFIED, m.Type, typ)
+		assert.Equal(t, typ, fromMessage(m).Type)
+	}
+	assert.Equal(t, "", fromMessage(&Message{}).Type)
+}
+
+func TestServer(t *testing.T) {
+	hub := chat.NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := NewServer(hub, Options{}, func() []string { return []string{"secret"} })
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	cc, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cc.Close()
+	client := NewChatClient(cc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = client.ListRooms(ctx, &ListRoomsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	rooms, err := client.ListRooms(ctx, &ListRoomsRequest{})
+	assert.NoError(t, err)
+	if assert.Len(t, rooms.Rooms, 1) {
+		assert.Equal(t, rm.ID, rooms.Rooms[0].Id)
+	}
+	_, err = client.GetRoom(ctx, &GetRoomRequest{Room: "nope"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	created, err := client.CreateRoom(ctx, &CreateRoomRequest{Name: "random", Owner: "svc"})
+	assert.NoError(t, err)
+	assert.Equal(t, "svc", created.Owner)
+	_, err = client.CreateRoom(ctx, &CreateRoomRequest{Name: "random"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	deleted, err := client.DeleteRoom(ctx, &DeleteRoomRequest{Room: "random"})
+	assert.NoError(t, err)
+	assert.Equal(t, created.Id, deleted.Id)
+
+	// web user
+	local, web := chat.Pipe()
+	hub.Connect(local)
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_JOIN, From: "web", Room: "general"}))
+
+	stream, err := client.Connect(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	recv := func(typ Type) *Message {
+		for {
+			m, err := stream.Recv()
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			if m.Type == typ {
+				return m
+			}
+		}
+	}
+	assert.NoError(t, stream.Send(&Message{Type: Type_TYPE_ROOMS, From: "svc"}))
+	assert.Len(t, recv(Type_TYPE_ROOMS).Rooms, 1)
+	assert.NoError(t, stream.Send(&Message{Type: Type_TYPE_JOIN, From: "svc", Room: "general"}))
+	assert.Equal(t, rm.ID, recv(Type_TYPE_JOIN).Room)
+
+	// grpc to web
+	assert.NoError(t, stream.Send(&Message{Type: Type_TYPE_MESSAGE, From: "svc", Room: rm.ID, Data: "hi from grpc"}))
+	for {
+		msg, err := web.ReadMessage()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if msg.Type == chat.T_MESSAGE {
+			assert.Equal(t, "svc", msg.From)
+			assert.Equal(t, "hi from grpc", msg.Data)
+			break
+		}
+	}
+	assert.Equal(t, "hi from grpc", recv(Type_TYPE_MESSAGE).Data)
+
+	// web to grpc, read web messages so the pipe isn't blocked
+	go func() {
+		for {
+			if _, err := web.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_MESSAGE, From: "web", Room: rm.ID, Data: "hello"}))
+	m := recv(Type_TYPE_MESSAGE)
+	assert.Equal(t, "web", m.From)
+	assert.Equal(t, "hello", m.Data)
+
+	posted, err := client.PostMessage(ctx, &PostMessageRequest{Room: "general", Data: "posted"})
+	assert.NoError(t, err)
+	assert.Equal(t, "grpc", posted.From)
+	assert.Equal(t, "posted", recv(Type_TYPE_MESSAGE).Data)
+	_, err = client.PostMessage(ctx, &PostMessageRequest{Room: "general"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	history, err := client.ListMessages(ctx, &ListMessagesRequest{Room: "general", Limit: 2})
+	assert.NoError(t, err)
+	if assert.Len(t, history.Messages, 2) {
+		assert.Equal(t, "posted", history.Messages[1].Data)
+	}
+
+	assert.NoError(t, stream.CloseSend())
+	web.Close()
+	assert.Eventually(t, func() bool {
+		return len(hub.Clients()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
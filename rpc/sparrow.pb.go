@@ -0,0 +1,907 @@
+// Chat API of sparrow for services, the same chat as the websocket one:
+// Connect streams carry the commands of websocket messages, and unary
+// rpcs manage rooms like the REST api. Calls need metadata
+// "authorization: Bearer <token>" with one of the api tokens.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        v5.29.3
+// source: sparrow.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Type of message, the same as "type" of websocket messages.
+type Type int32
+
+const (
+	Type_TYPE_UNSPECIFIED Type = 0
+	Type_TYPE_JOIN        Type = 1  // c -> s, join room, data or room is the room
+	Type_TYPE_LEAVE       Type = 2  // c -> s, leave room
+	Type_TYPE_CREATE      Type = 3  // c -> s, create room named data
+	Type_TYPE_CLOSE       Type = 4  // s -> c, room closed
+	Type_TYPE_ROOMS       Type = 5  // c -> s, get room list
+	Type_TYPE_RENAME      Type = 6  // c -> s, rename room to data, owner only
+	Type_TYPE_UPDATE_ROOM Type = 7  // c -> s, update room metadata in json data, owner only
+	Type_TYPE_MESSAGE     Type = 8  // c <-> s, message
+	Type_TYPE_SYSTEM      Type = 9  // s -> c, room event generated by server
+	Type_TYPE_ERROR       Type = 10 // s -> c, request failed
+)
+
+// Enum value maps for Type.
+var (
+	Type_name = map[int32]string{
+		0:  "TYPE_UNSPECIFIED",
+		1:  "TYPE_JOIN",
+		2:  "TYPE_LEAVE",
+		3:  "TYPE_CREATE",
+		4:  "TYPE_CLOSE",
+		5:  "TYPE_ROOMS",
+		6:  "TYPE_RENAME",
+		7:  "TYPE_UPDATE_ROOM",
+		8:  "TYPE_MESSAGE",
+		9:  "TYPE_SYSTEM",
+		10: "TYPE_ERROR",
+	}
+	Type_value = map[string]int32{
+		"TYPE_UNSPECIFIED": 0,
+		"TYPE_JOIN":        1,
+		"TYPE_LEAVE":       2,
+		"TYPE_CREATE":      3,
+		"TYPE_CLOSE":       4,
+		"TYPE_ROOMS":       5,
+		"TYPE_RENAME":      6,
+		"TYPE_UPDATE_ROOM": 7,
+		"TYPE_MESSAGE":     8,
+		"TYPE_SYSTEM":      9,
+		"TYPE_ERROR":       10,
+	}
+)
+
+func (x Type) Enum() *Type {
+	p := new(Type)
+	*p = x
+	return p
+}
+
+func (x Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_sparrow_proto_enumTypes[0].Descriptor()
+}
+
+func (Type) Type() protoreflect.EnumType {
+	return &file_sparrow_proto_enumTypes[0]
+}
+
+func (x Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Type.Descriptor instead.
+func (Type) EnumDescriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{0}
+}
+
+type Message struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type          Type                   `protobuf:"varint,2,opt,name=type,proto3,enum=sparrow.v1.Type" json:"type,omitempty"`
+	From          string                 `protobuf:"bytes,3,opt,name=from,proto3" json:"from,omitempty"`            // user name of sender
+	Room          string                 `protobuf:"bytes,4,opt,name=room,proto3" json:"room,omitempty"`            // room id, or name when sent by clients
+	Timestamp     int64                  `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // unix milliseconds
+	Data          string                 `protobuf:"bytes,6,opt,name=data,proto3" json:"data,omitempty"`
+	Event         string                 `protobuf:"bytes,7,opt,name=event,proto3" json:"event,omitempty"`                                                                         // event of SYSTEM messages, e.g. "join"
+	Meta          map[string]string      `protobuf:"bytes,8,rep,name=meta,proto3" json:"meta,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // event arguments
+	Rooms         []*Room                `protobuf:"bytes,9,rep,name=rooms,proto3" json:"rooms,omitempty"`                                                                         // rooms of ROOMS, CREATE, RENAME and UPDATE_ROOM replies
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Message) Reset() {
+	*x = Message{}
+	mi := &file_sparrow_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Message) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Message) ProtoMessage() {}
+
+func (x *Message) ProtoReflect() protoreflect.Message {
+	mi := &file_sparrow_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Message.ProtoReflect.Descriptor instead.
+func (*Message) Descriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Message) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Message) GetType() Type {
+	if x != nil {
+		return x.Type
+	}
+	return Type_TYPE_UNSPECIFIED
+}
+
+func (x *Message) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *Message) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+func (x *Message) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Message) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+func (x *Message) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *Message) GetMeta() map[string]string {
+	if x != nil {
+		return x.Meta
+	}
+	return nil
+}
+
+func (x *Message) GetRooms() []*Room {
+	if x != nil {
+		return x.Rooms
+	}
+	return nil
+}
+
+type Room struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Slug          string                 `protobuf:"bytes,3,opt,name=slug,proto3" json:"slug,omitempty"`
+	Aliases       []string               `protobuf:"bytes,4,rep,name=aliases,proto3" json:"aliases,omitempty"`
+	Owner         string                 `protobuf:"bytes,5,opt,name=owner,proto3" json:"owner,omitempty"`
+	Topic         string                 `protobuf:"bytes,6,opt,name=topic,proto3" json:"topic,omitempty"`
+	Desc          string                 `protobuf:"bytes,7,opt,name=desc,proto3" json:"desc,omitempty"`
+	Avatar        string                 `protobuf:"bytes,8,opt,name=avatar,proto3" json:"avatar,omitempty"`
+	Pinned        []uint64               `protobuf:"varint,9,rep,packed,name=pinned,proto3" json:"pinned,omitempty"`
+	Muted         []string               `protobuf:"bytes,10,rep,name=muted,proto3" json:"muted,omitempty"`
+	Active        bool                   `protobuf:"varint,11,opt,name=active,proto3" json:"active,omitempty"`
+	ClientCount   int32                  `protobuf:"varint,12,opt,name=client_count,json=clientCount,proto3" json:"client_count,omitempty"`
+	MessageCount  int32                  `protobuf:"varint,13,opt,name=message_count,json=messageCount,proto3" json:"message_count,omitempty"`
+	Updated       *timestamppb.Timestamp `protobuf:"bytes,14,opt,name=updated,proto3" json:"updated,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Room) Reset() {
+	*x = Room{}
+	mi := &file_sparrow_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Room) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Room) ProtoMessage() {}
+
+func (x *Room) ProtoReflect() protoreflect.Message {
+	mi := &file_sparrow_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Room.ProtoReflect.Descriptor instead.
+func (*Room) Descriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Room) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Room) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Room) GetSlug() string {
+	if x != nil {
+		return x.Slug
+	}
+	return ""
+}
+
+func (x *Room) GetAliases() []string {
+	if x != nil {
+		return x.Aliases
+	}
+	return nil
+}
+
+func (x *Room) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+func (x *Room) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *Room) GetDesc() string {
+	if x != nil {
+		return x.Desc
+	}
+	return ""
+}
+
+func (x *Room) GetAvatar() string {
+	if x != nil {
+		return x.Avatar
+	}
+	return ""
+}
+
+func (x *Room) GetPinned() []uint64 {
+	if x != nil {
+		return x.Pinned
+	}
+	return nil
+}
+
+func (x *Room) GetMuted() []string {
+	if x != nil {
+		return x.Muted
+	}
+	return nil
+}
+
+func (x *Room) GetActive() bool {
+	if x != nil {
+		return x.Active
+	}
+	return false
+}
+
+func (x *Room) GetClientCount() int32 {
+	if x != nil {
+		return x.ClientCount
+	}
+	return 0
+}
+
+func (x *Room) GetMessageCount() int32 {
+	if x != nil {
+		return x.MessageCount
+	}
+	return 0
+}
+
+func (x *Room) GetUpdated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Updated
+	}
+	return nil
+}
+
+type ListRoomsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRoomsRequest) Reset() {
+	*x = ListRoomsRequest{}
+	mi := &file_sparrow_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRoomsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRoomsRequest) ProtoMessage() {}
+
+func (x *ListRoomsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sparrow_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRoomsRequest.ProtoReflect.Descriptor instead.
+func (*ListRoomsRequest) Descriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{2}
+}
+
+type ListRoomsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rooms         []*Room                `protobuf:"bytes,1,rep,name=rooms,proto3" json:"rooms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRoomsResponse) Reset() {
+	*x = ListRoomsResponse{}
+	mi := &file_sparrow_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRoomsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRoomsResponse) ProtoMessage() {}
+
+func (x *ListRoomsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_sparrow_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRoomsResponse.ProtoReflect.Descriptor instead.
+func (*ListRoomsResponse) Descriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListRoomsResponse) GetRooms() []*Room {
+	if x != nil {
+		return x.Rooms
+	}
+	return nil
+}
+
+type GetRoomRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Room          string                 `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"` // room id, name or alias
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRoomRequest) Reset() {
+	*x = GetRoomRequest{}
+	mi := &file_sparrow_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRoomRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRoomRequest) ProtoMessage() {}
+
+func (x *GetRoomRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sparrow_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRoomRequest.ProtoReflect.Descriptor instead.
+func (*GetRoomRequest) Descriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetRoomRequest) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+type CreateRoomRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Owner         string                 `protobuf:"bytes,2,opt,name=owner,proto3" json:"owner,omitempty"` // user owns the room, none if empty
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateRoomRequest) Reset() {
+	*x = CreateRoomRequest{}
+	mi := &file_sparrow_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateRoomRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateRoomRequest) ProtoMessage() {}
+
+func (x *CreateRoomRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sparrow_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateRoomRequest.ProtoReflect.Descriptor instead.
+func (*CreateRoomRequest) Descriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CreateRoomRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CreateRoomRequest) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+type DeleteRoomRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Room          string                 `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRoomRequest) Reset() {
+	*x = DeleteRoomRequest{}
+	mi := &file_sparrow_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRoomRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRoomRequest) ProtoMessage() {}
+
+func (x *DeleteRoomRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sparrow_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRoomRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRoomRequest) Descriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DeleteRoomRequest) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+type ListMessagesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Room          string                 `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	Before        uint64                 `protobuf:"varint,2,opt,name=before,proto3" json:"before,omitempty"` // messages before this id, latest if 0
+	Limit         int32                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`   // 50 if 0, at most 500
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMessagesRequest) Reset() {
+	*x = ListMessagesRequest{}
+	mi := &file_sparrow_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMessagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMessagesRequest) ProtoMessage() {}
+
+func (x *ListMessagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sparrow_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMessagesRequest.ProtoReflect.Descriptor instead.
+func (*ListMessagesRequest) Descriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListMessagesRequest) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+func (x *ListMessagesRequest) GetBefore() uint64 {
+	if x != nil {
+		return x.Before
+	}
+	return 0
+}
+
+func (x *ListMessagesRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListMessagesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Messages      []*Message             `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMessagesResponse) Reset() {
+	*x = ListMessagesResponse{}
+	mi := &file_sparrow_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMessagesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMessagesResponse) ProtoMessage() {}
+
+func (x *ListMessagesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_sparrow_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMessagesResponse.ProtoReflect.Descriptor instead.
+func (*ListMessagesResponse) Descriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListMessagesResponse) GetMessages() []*Message {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+type PostMessageRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Room          string                 `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	From          string                 `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"` // sender shown to users, "grpc" if empty
+	Data          string                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PostMessageRequest) Reset() {
+	*x = PostMessageRequest{}
+	mi := &file_sparrow_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PostMessageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PostMessageRequest) ProtoMessage() {}
+
+func (x *PostMessageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sparrow_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PostMessageRequest.ProtoReflect.Descriptor instead.
+func (*PostMessageRequest) Descriptor() ([]byte, []int) {
+	return file_sparrow_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *PostMessageRequest) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+func (x *PostMessageRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *PostMessageRequest) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+var File_sparrow_proto protoreflect.FileDescriptor
+
+const file_sparrow_proto_rawDesc = "" +
+	"\n" +
+	"\rsparrow.proto\x12\n" +
+	"sparrow.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xc3\x02\n" +
+	"\aMessage\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12$\n" +
+	"\x04type\x18\x02 \x01(\x0e2\x10.sparrow.v1.TypeR\x04type\x12\x12\n" +
+	"\x04from\x18\x03 \x01(\tR\x04from\x12\x12\n" +
+	"\x04room\x18\x04 \x01(\tR\x04room\x12\x1c\n" +
+	"\ttimestamp\x18\x05 \x01(\x03R\ttimestamp\x12\x12\n" +
+	"\x04data\x18\x06 \x01(\tR\x04data\x12\x14\n" +
+	"\x05event\x18\a \x01(\tR\x05event\x121\n" +
+	"\x04meta\x18\b \x03(\v2\x1d.sparrow.v1.Message.MetaEntryR\x04meta\x12&\n" +
+	"\x05rooms\x18\t \x03(\v2\x10.sparrow.v1.RoomR\x05rooms\x1a7\n" +
+	"\tMetaEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xf4\x02\n" +
+	"\x04Room\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x12\n" +
+	"\x04slug\x18\x03 \x01(\tR\x04slug\x12\x18\n" +
+	"\aaliases\x18\x04 \x03(\tR\aaliases\x12\x14\n" +
+	"\x05owner\x18\x05 \x01(\tR\x05owner\x12\x14\n" +
+	"\x05topic\x18\x06 \x01(\tR\x05topic\x12\x12\n" +
+	"\x04desc\x18\a \x01(\tR\x04desc\x12\x16\n" +
+	"\x06avatar\x18\b \x01(\tR\x06avatar\x12\x16\n" +
+	"\x06pinned\x18\t \x03(\x04R\x06pinned\x12\x14\n" +
+	"\x05muted\x18\n" +
+	" \x03(\tR\x05muted\x12\x16\n" +
+	"\x06active\x18\v \x01(\bR\x06active\x12!\n" +
+	"\fclient_count\x18\f \x01(\x05R\vclientCount\x12#\n" +
+	"\rmessage_count\x18\r \x01(\x05R\fmessageCount\x124\n" +
+	"\aupdated\x18\x0e \x01(\v2\x1a.google.protobuf.TimestampR\aupdated\"\x12\n" +
+	"\x10ListRoomsRequest\";\n" +
+	"\x11ListRoomsResponse\x12&\n" +
+	"\x05rooms\x18\x01 \x03(\v2\x10.sparrow.v1.RoomR\x05rooms\"$\n" +
+	"\x0eGetRoomRequest\x12\x12\n" +
+	"\x04room\x18\x01 \x01(\tR\x04room\"=\n" +
+	"\x11CreateRoomRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05owner\x18\x02 \x01(\tR\x05owner\"'\n" +
+	"\x11DeleteRoomRequest\x12\x12\n" +
+	"\x04room\x18\x01 \x01(\tR\x04room\"W\n" +
+	"\x13ListMessagesRequest\x12\x12\n" +
+	"\x04room\x18\x01 \x01(\tR\x04room\x12\x16\n" +
+	"\x06before\x18\x02 \x01(\x04R\x06before\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\"G\n" +
+	"\x14ListMessagesResponse\x12/\n" +
+	"\bmessages\x18\x01 \x03(\v2\x13.sparrow.v1.MessageR\bmessages\"P\n" +
+	"\x12PostMessageRequest\x12\x12\n" +
+	"\x04room\x18\x01 \x01(\tR\x04room\x12\x12\n" +
+	"\x04from\x18\x02 \x01(\tR\x04from\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\tR\x04data*\xc6\x01\n" +
+	"\x04Type\x12\x14\n" +
+	"\x10TYPE_UNSPECIFIED\x10\x00\x12\r\n" +
+	"\tTYPE_JOIN\x10\x01\x12\x0e\n" +
+	"\n" +
+	"TYPE_LEAVE\x10\x02\x12\x0f\n" +
+	"\vTYPE_CREATE\x10\x03\x12\x0e\n" +
+	"\n" +
+	"TYPE_CLOSE\x10\x04\x12\x0e\n" +
+	"\n" +
+	"TYPE_ROOMS\x10\x05\x12\x0f\n" +
+	"\vTYPE_RENAME\x10\x06\x12\x14\n" +
+	"\x10TYPE_UPDATE_ROOM\x10\a\x12\x10\n" +
+	"\fTYPE_MESSAGE\x10\b\x12\x0f\n" +
+	"\vTYPE_SYSTEM\x10\t\x12\x0e\n" +
+	"\n" +
+	"TYPE_ERROR\x10\n" +
+	"2\xd7\x03\n" +
+	"\x04Chat\x127\n" +
+	"\aConnect\x12\x13.sparrow.v1.Message\x1a\x13.sparrow.v1.Message(\x010\x01\x12H\n" +
+	"\tListRooms\x12\x1c.sparrow.v1.ListRoomsRequest\x1a\x1d.sparrow.v1.ListRoomsResponse\x127\n" +
+	"\aGetRoom\x12\x1a.sparrow.v1.GetRoomRequest\x1a\x10.sparrow.v1.Room\x12=\n" +
+	"\n" +
+	"CreateRoom\x12\x1d.sparrow.v1.CreateRoomRequest\x1a\x10.sparrow.v1.Room\x12=\n" +
+	"\n" +
+	"DeleteRoom\x12\x1d.sparrow.v1.DeleteRoomRequest\x1a\x10.sparrow.v1.Room\x12Q\n" +
+	"\fListMessages\x12\x1f.sparrow.v1.ListMessagesRequest\x1a .sparrow.v1.ListMessagesResponse\x12B\n" +
+	"\vPostMessage\x12\x1e.sparrow.v1.PostMessageRequest\x1a\x13.sparrow.v1.MessageB)Z'github.com/lucasjinreal/sparrow/rpc;rpcb\x06proto3"
+
+var (
+	file_sparrow_proto_rawDescOnce sync.Once
+	file_sparrow_proto_rawDescData []byte
+)
+
+func file_sparrow_proto_rawDescGZIP() []byte {
+	file_sparrow_proto_rawDescOnce.Do(func() {
+		file_sparrow_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_sparrow_proto_rawDesc), len(file_sparrow_proto_rawDesc)))
+	})
+	return file_sparrow_proto_rawDescData
+}
+
+var file_sparrow_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_sparrow_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_sparrow_proto_goTypes = []any{
+	(Type)(0),                     // 0: sparrow.v1.Type
+	(*Message)(nil),               // 1: sparrow.v1.Message
+	(*Room)(nil),                  // 2: sparrow.v1.Room
+	(*ListRoomsRequest)(nil),      // 3: sparrow.v1.ListRoomsRequest
+	(*ListRoomsResponse)(nil),     // 4: sparrow.v1.ListRoomsResponse
+	(*GetRoomRequest)(nil),        // 5: sparrow.v1.GetRoomRequest
+	(*CreateRoomRequest)(nil),     // 6: sparrow.v1.CreateRoomRequest
+	(*DeleteRoomRequest)(nil),     // 7: sparrow.v1.DeleteRoomRequest
+	(*ListMessagesRequest)(nil),   // 8: sparrow.v1.ListMessagesRequest
+	(*ListMessagesResponse)(nil),  // 9: sparrow.v1.ListMessagesResponse
+	(*PostMessageRequest)(nil),    // 10: sparrow.v1.PostMessageRequest
+	nil,                           // 11: sparrow.v1.Message.MetaEntry
+	(*timestamppb.Timestamp)(nil), // 12: google.protobuf.Timestamp
+}
+var file_sparrow_proto_depIdxs = []int32{
+	0,  // 0: sparrow.v1.Message.type:type_name -> sparrow.v1.Type
+	11, // 1: sparrow.v1.Message.meta:type_name -> sparrow.v1.Message.MetaEntry
+	2,  // 2: sparrow.v1.Message.rooms:type_name -> sparrow.v1.Room
+	12, // 3: sparrow.v1.Room.updated:type_name -> google.protobuf.Timestamp
+	2,  // 4: sparrow.v1.ListRoomsResponse.rooms:type_name -> sparrow.v1.Room
+	1,  // 5: sparrow.v1.ListMessagesResponse.messages:type_name -> sparrow.v1.Message
+	1,  // 6: sparrow.v1.Chat.Connect:input_type -> sparrow.v1.Message
+	3,  // 7: sparrow.v1.Chat.ListRooms:input_type -> sparrow.v1.ListRoomsRequest
+	5,  // 8: sparrow.v1.Chat.GetRoom:input_type -> sparrow.v1.GetRoomRequest
+	6,  // 9: sparrow.v1.Chat.CreateRoom:input_type -> sparrow.v1.CreateRoomRequest
+	7,  // 10: sparrow.v1.Chat.DeleteRoom:input_type -> sparrow.v1.DeleteRoomRequest
+	8,  // 11: sparrow.v1.Chat.ListMessages:input_type -> sparrow.v1.ListMessagesRequest
+	10, // 12: sparrow.v1.Chat.PostMessage:input_type -> sparrow.v1.PostMessageRequest
+	1,  // 13: sparrow.v1.Chat.Connect:output_type -> sparrow.v1.Message
+	4,  // 14: sparrow.v1.Chat.ListRooms:output_type -> sparrow.v1.ListRoomsResponse
+	2,  // 15: sparrow.v1.Chat.GetRoom:output_type -> sparrow.v1.Room
+	2,  // 16: sparrow.v1.Chat.CreateRoom:output_type -> sparrow.v1.Room
+	2,  // 17: sparrow.v1.Chat.DeleteRoom:output_type -> sparrow.v1.Room
+	9,  // 18: sparrow.v1.Chat.ListMessages:output_type -> sparrow.v1.ListMessagesResponse
+	1,  // 19: sparrow.v1.Chat.PostMessage:output_type -> sparrow.v1.Message
+	13, // [13:20] is the sub-list for method output_type
+	6,  // [6:13] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_sparrow_proto_init() }
+func file_sparrow_proto_init() {
+	if File_sparrow_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_sparrow_proto_rawDesc), len(file_sparrow_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_sparrow_proto_goTypes,
+		DependencyIndexes: file_sparrow_proto_depIdxs,
+		EnumInfos:         file_sparrow_proto_enumTypes,
+		MessageInfos:      file_sparrow_proto_msgTypes,
+	}.Build()
+	File_sparrow_proto = out.File
+	file_sparrow_proto_goTypes = nil
+	file_sparrow_proto_depIdxs = nil
+}
@@ -0,0 +1,134 @@
+package std
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket token bucket rate limiter, it is safe for concurrent use.
+// Tokens are refilled at rate per second, up to burst tokens.
+type Bucket struct {
+	lck    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket create an new full bucket, rate <= 0 means unlimited
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow take one token from bucket, return false if there is none left
+func (b *Bucket) Allow() bool {
+	return b.AllowAt(time.Now())
+}
+
+// AllowAt take one token from bucket at given time
+func (b *Bucket) AllowAt(now time.Time) bool {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+
+	if b.rate <= 0 {
+		return true
+	}
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Full check if bucket has been refilled to burst at given time
+func (b *Bucket) Full(now time.Time) bool {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// Limiter keeps a token bucket for each key, such as user name or ip.
+// Buckets which have been refilled are dropped periodically.
+type Limiter struct {
+	lck     sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*Bucket
+	swept   time.Time
+}
+
+// limiterSweep interval to drop refilled buckets
+const limiterSweep = time.Minute
+
+// NewLimiter create keyed limiter, rate <= 0 means unlimited
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*Bucket),
+		swept:   time.Now(),
+	}
+}
+
+// SetRate change rate of limiter, existing buckets are reset
+func (l *Limiter) SetRate(rate float64, burst int) {
+	l.lck.Lock()
+	l.rate, l.burst = rate, burst
+	l.buckets = make(map[string]*Bucket)
+	l.lck.Unlock()
+}
+
+// Allow take one token from the bucket of given key
+func (l *Limiter) Allow(key string) bool {
+	now := time.Now()
+
+	l.lck.Lock()
+	if l.rate <= 0 {
+		l.lck.Unlock()
+		return true
+	}
+	if now.Sub(l.swept) > limiterSweep {
+		for k, b := range l.buckets {
+			if b.Full(now) {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewBucket(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+	l.lck.Unlock()
+
+	return b.AllowAt(now)
+}
+
+// Len return number of buckets in limiter
+func (l *Limiter) Len() int {
+	l.lck.Lock()
+	n := len(l.buckets)
+	l.lck.Unlock()
+	return n
+}
@@ -0,0 +1,48 @@
+package std
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.AllowAt(now))
+	}
+	assert.False(t, b.AllowAt(now))
+	assert.False(t, b.Full(now))
+
+	// half a second refills one token
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, b.AllowAt(now))
+	assert.False(t, b.AllowAt(now))
+
+	now = now.Add(10 * time.Second)
+	assert.True(t, b.Full(now))
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	b := NewBucket(0, 0)
+	for i := 0; i < 1000; i++ {
+		assert.True(t, b.Allow())
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(1, 2)
+
+	assert.True(t, l.Allow("alice"))
+	assert.True(t, l.Allow("alice"))
+	assert.False(t, l.Allow("alice"))
+	assert.True(t, l.Allow("bob"))
+	assert.Equal(t, 2, l.Len())
+
+	l.SetRate(0, 0)
+	assert.True(t, l.Allow("alice"))
+	assert.Equal(t, 0, l.Len())
+}
@@ -0,0 +1,335 @@
+package telnet
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"../chat"
+	"../gateway"
+)
+
+const (
+	// longest line accepted from clients, longer ones close the connection
+	maxLine = 4096
+	// time allowed to send the user name after connected
+	loginWait = time.Minute
+)
+
+const help = `* commands:
+*   /rooms          list rooms
+*   /join <room>    join room by name, it becomes the current room
+*   /leave [room]   leave current or given room
+*   /create <name>  create room
+*   /quit           disconnect
+* other lines are messages of the current room`
+
+// conn is the chat.Conn of a line based client, command lines are
+// translated into messages of the hub, and messages from the hub into
+// human readable lines.
+type conn struct {
+	srv  *Server
+	nc   net.Conn
+	opts chat.Options
+	in   *bufio.Scanner
+
+	wlck sync.Mutex // guards writes
+	w    *bufio.Writer
+
+	lck     sync.Mutex
+	user    string
+	current string            // room id messages are sent to
+	rooms   map[string]string // joined room id -> name
+
+	*gateway.Queue // messages of lines not read yet
+}
+
+func newConn(srv *Server, nc net.Conn) *conn {
+	in := bufio.NewScanner(nc)
+	in.Buffer(make([]byte, 512), maxLine)
+	c := &conn{
+		srv:   srv,
+		nc:    nc,
+		opts:  srv.hub.Options(),
+		in:    in,
+		w:     bufio.NewWriter(nc),
+		rooms: make(map[string]string),
+	}
+	c.Queue = gateway.NewQueue(c.next, c.opts.MaxMessageSize)
+	return c
+}
+
+func (c *conn) getUser() string {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	return c.user
+}
+
+// roomName return name of joined room
+func (c *conn) roomName(roomID string) (string, bool) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	name, ok := c.rooms[roomID]
+	return name, ok
+}
+
+// readLine read next line without telnet negotiations
+func (c *conn) readLine() (string, error) {
+	if !c.in.Scan() {
+		if err := c.in.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return clean(c.in.Text()), nil
+}
+
+// println write sanitized lines, ending with CRLF for telnet clients
+func (c *conn) println(lines ...string) error {
+	c.wlck.Lock()
+	defer c.wlck.Unlock()
+	c.nc.SetWriteDeadline(time.Now().Add(c.opts.WriteWait))
+	for _, line := range lines {
+		if _, err := c.w.WriteString(sanitize(line) + "\r\n"); err != nil {
+			return err
+		}
+	}
+	return c.w.Flush()
+}
+
+// login ask the user name
+func (c *conn) login() error {
+	c.nc.SetReadDeadline(time.Now().Add(loginWait))
+	if err := c.println("* welcome to sparrow, your name?"); err != nil {
+		return err
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		name := line
+		if strings.HasPrefix(line, "/nick ") {
+			name = strings.TrimSpace(line[len("/nick "):])
+		}
+		if name != "" && !strings.HasPrefix(name, "/") {
+			c.user = name
+			break
+		}
+		c.println("* your name?")
+	}
+	// idle users are fine, TCP keep alive finds peers that have gone
+	c.nc.SetReadDeadline(time.Time{})
+	return c.println("* hi "+c.user+", /rooms lists rooms, /join <room> joins one", "* /help for all commands")
+}
+
+// next read and handle next line, hub messages of it are queued
+func (c *conn) next() error {
+	line, err := c.readLine()
+	if err != nil || line == "" {
+		return err
+	}
+	return c.command(line)
+}
+
+// queue message for hub, sent by this client
+func (c *conn) queue(typ, room, data string) {
+	if err := c.Add(typ, c.getUser(), room, data); err != nil {
+		c.println(fmt.Sprintf("! message longer than %d bytes", c.opts.MaxMessageSize))
+	}
+}
+
+// command handle a line of client, io.EOF if client quits
+func (c *conn) command(line string) error {
+	if !strings.HasPrefix(line, "/") || strings.HasPrefix(line, "//") {
+		// "//" sends a message starting with "/"
+		c.lck.Lock()
+		room := c.current
+		c.lck.Unlock()
+		if room == "" {
+			return c.println("! no room joined, /rooms lists rooms, /join <room> joins one")
+		}
+		c.queue(chat.T_MESSAGE, room, strings.TrimPrefix(line, "/"))
+		return nil
+	}
+
+	cmd, arg := line, ""
+	if i := strings.IndexByte(line, ' '); i > 0 {
+		cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch strings.ToLower(cmd) {
+	case "/help":
+		return c.println(strings.Split(help, "\n")...)
+
+	case "/quit":
+		c.println("* bye")
+		return io.EOF
+
+	case "/rooms":
+		c.queue(chat.T_ROOMS, "", "")
+
+	case "/nick":
+		// the hub binds the user name to the connection
+		return c.println("! name can't be changed, reconnect to use another one")
+
+	case "/join":
+		if arg == "" {
+			return c.println("! usage: /join <room>")
+		}
+		// joined already, just switch to it
+		if rm := c.srv.hub.GetRoom(arg); rm != nil {
+			if _, ok := c.roomName(rm.ID); ok {
+				c.lck.Lock()
+				c.current = rm.ID
+				c.lck.Unlock()
+				return c.println("* talking in " + rm.Name)
+			}
+		}
+		c.queue(chat.T_JOIN, arg, "")
+
+	case "/leave":
+		c.lck.Lock()
+		room := c.current
+		c.lck.Unlock()
+		if arg != "" {
+			room = ""
+			if rm := c.srv.hub.GetRoom(arg); rm != nil {
+				room = rm.ID
+			}
+		}
+		if _, ok := c.roomName(room); !ok {
+			return c.println("! not in that room")
+		}
+		c.queue(chat.T_LEAVE, room, "")
+
+	case "/create":
+		if arg == "" {
+			return c.println("! usage: /create <name>")
+		}
+		c.queue(chat.T_CREATE, "", arg)
+
+	default:
+		return c.println("! unknown command " + cmd + ", /help lists commands")
+	}
+	return nil
+}
+
+// WriteMessage translate message of hub into text lines
+func (c *conn) WriteMessage(msg *chat.Message) error {
+	if err := c.write(msg); err != nil {
+		return io.EOF
+	}
+	return nil
+}
+
+func (c *conn) write(msg *chat.Message) error {
+	switch msg.Type {
+	case chat.T_JOIN:
+		rm := c.srv.hub.GetRoom(msg.Data)
+		if rm == nil {
+			return nil
+		}
+		c.lck.Lock()
+		c.rooms[rm.ID] = rm.Name
+		c.current = rm.ID
+		c.lck.Unlock()
+		lines := []string{"* joined " + rm.Name + ", messages go there now"}
+		if rm.Topic != "" {
+			lines = append(lines, "* topic: "+rm.Topic)
+		}
+		return c.println(lines...)
+
+	case chat.T_LEAVE:
+		name, ok := c.roomName(msg.Data)
+		if !ok {
+			return nil
+		}
+		c.forget(msg.Data)
+		return c.println("* left " + name)
+
+	case chat.T_MESSAGE:
+		name, ok := c.roomName(msg.Room)
+		// own messages are on the screen already, others of the same
+		// name are shown
+		if !ok || msg.Source == chat.Conn(c) {
+			return nil
+		}
+		var lines []string
+		for _, line := range strings.Split(msg.Data, "\n") {
+			lines = append(lines, fmt.Sprintf("[%s] %s: %s", name, msg.From, strings.TrimRight(line, "\r")))
+		}
+		return c.println(lines...)
+
+	case chat.T_SYSTEM:
+		name, ok := c.roomName(msg.Room)
+		if !ok {
+			return nil
+		}
+		return c.println(fmt.Sprintf("[%s] * %s", name, msg.Data))
+
+	case chat.T_CLOSE:
+		name, ok := c.roomName(msg.Room)
+		if !ok {
+			return nil
+		}
+		c.forget(msg.Room)
+		return c.println("* " + name + " closed")
+
+	case chat.T_ROOMS:
+		var rooms []*chat.RoomInfo
+		json.Unmarshal([]byte(msg.Data), &rooms)
+		lines := []string{fmt.Sprintf("* %d rooms:", len(rooms))}
+		for _, rm := range rooms {
+			line := fmt.Sprintf("*   %s (%d online)", rm.Name, rm.CCount)
+			if rm.Topic != "" {
+				line += " " + rm.Topic
+			}
+			lines = append(lines, line)
+		}
+		return c.println(lines...)
+
+	case chat.T_CREATE:
+		var rm chat.RoomInfo
+		json.Unmarshal([]byte(msg.Data), &rm)
+		return c.println("* created " + rm.Name + ", /join " + rm.Name + " to join it")
+
+	case chat.T_ERROR:
+		if name, ok := c.roomName(msg.Room); ok {
+			return c.println(fmt.Sprintf("! %s: %s", name, msg.Data))
+		}
+		return c.println("! " + msg.Data)
+	}
+	return nil
+}
+
+// forget left room, the current room falls back to another joined one
+func (c *conn) forget(roomID string) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	delete(c.rooms, roomID)
+	if c.current == roomID {
+		c.current = ""
+		for id := range c.rooms {
+			c.current = id
+			break
+		}
+	}
+}
+
+// Ping do nothing, anything sent would show up on the screen
+func (c *conn) Ping() error {
+	return nil
+}
+
+func (c *conn) Close() error {
+	return c.nc.Close()
+}
+
+func (c *conn) RemoteAddr() string {
+	return c.nc.RemoteAddr().String()
+}
@@ -0,0 +1,128 @@
+// Package telnet is a line based chat protocol over plain TCP, so `nc host
+// port` or a telnet client is enough to chat, e.g. for debugging or very
+// constrained devices. Lines starting with "/" are commands, like /join
+// and /rooms, other lines are messages of the current room. Output is
+// human readable text, one line each.
+package telnet
+
+import (
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"../chat"
+	"../gateway"
+	"../logging"
+)
+
+var log = logging.Logger(logging.Telnet)
+
+// Options of the telnet listener
+type Options struct {
+	Addr string `toml:"addr" yaml:"addr"` // listen address, e.g. :2323, disabled if empty
+}
+
+// ErrServerClosed returned by Serve after Close
+var ErrServerClosed = gateway.ErrServerClosed
+
+// Server accepts line based connections and serves them as clients of hub
+type Server struct {
+	*gateway.Listener
+	hub *chat.RoomHub
+}
+
+// NewServer create telnet listener of hub
+func NewServer(hub *chat.RoomHub, opts Options) *Server {
+	s := &Server{hub: hub}
+	s.Listener = gateway.NewListener("telnet listener", log, s.serveConn)
+	return s
+}
+
+// serveConn ask user name, then serve the connection as a hub client
+func (s *Server) serveConn(nc net.Conn) {
+	c := newConn(s, nc)
+	if err := c.login(); err != nil {
+		log.Debug("telnet login failed", "addr", c.RemoteAddr(), "err", err)
+		nc.Close()
+		return
+	}
+	client, err := s.hub.Connect(c)
+	if err != nil {
+		log.Debug("telnet client rejected", "user", c.getUser(), "addr", c.RemoteAddr(), "err", err)
+		return
+	}
+	log.Debug("telnet client connected", "client", client.ID(), "user", c.getUser(), "addr", c.RemoteAddr())
+}
+
+// clean strip telnet negotiations, control characters and terminal
+// escapes from line of client
+func clean(line string) string {
+	var sb strings.Builder
+	for i := 0; i < len(line); i++ {
+		b := line[i]
+		switch {
+		case b == 0xff && i+1 < len(line) && line[i+1] >= 0xfb && line[i+1] <= 0xfe:
+			// IAC WILL/WONT/DO/DONT option
+			i += 2
+		case b == 0xff:
+			// IAC command, subnegotiations are never asked for
+			i++
+		default:
+			sb.WriteByte(b)
+		}
+	}
+	return strings.TrimSpace(sanitize(sb.String()))
+}
+
+// sanitize strip control characters and terminal escapes, text of others
+// must not move the cursor, retitle the window or inject lines. Tabs and
+// line breaks become spaces, invalid UTF-8 like telnet IAC is dropped.
+func sanitize(text string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == 0x1b:
+			size = escapeLen(text[i:])
+		case r == '\t', r == '\n':
+			sb.WriteByte(' ')
+		case r < ' ', r == 0x7f, r >= 0x80 && r <= 0x9f, r == utf8.RuneError && size == 1:
+			// C0 and C1 controls, C1 CSI 0x9b starts escapes too
+		default:
+			sb.WriteString(text[i : i+size])
+		}
+		i += size
+	}
+	return sb.String()
+}
+
+// escapeLen return length of escape sequence at the start of s
+func escapeLen(s string) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+	switch s[1] {
+	case '[':
+		// CSI: parameters and intermediates, then a final byte
+		i := 2
+		for i < len(s) && s[i] >= 0x20 && s[i] <= 0x3f {
+			i++
+		}
+		if i < len(s) && s[i] >= 0x40 && s[i] <= 0x7e {
+			i++
+		}
+		return i
+	case ']', 'P', 'X', '^', '_':
+		// OSC, DCS and other strings, until BEL or ST
+		for i := 2; i < len(s); i++ {
+			if s[i] == 0x07 {
+				return i + 1
+			}
+			if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(s)
+	}
+	return 2
+}
@@ -0,0 +1,125 @@
+package telnet
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"../chat"
+	"../gateway/gatewaytest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClean(t *testing.T) {
+	assert.Equal(t, "hello", clean("\xff\xfd\x01hello\r"))
+	assert.Equal(t, "a b", clean(" a\x00 b\xff\xf1"))
+	assert.Equal(t, "你好", clean("你好"))
+	assert.Equal(t, "red", clean("\x1b[31mred\x1b[0m"))
+}
+
+func TestSanitize(t *testing.T) {
+	assert.Equal(t, "hi there", sanitize("hi\x1b]0;pwned\x07 there"))
+	assert.Equal(t, "hi there", sanitize("hi\x1b]0;pwned\x1b\\ there"))
+	assert.Equal(t, "clear", sanitize("\x1b[2J\x1b[Hclear"))
+	assert.Equal(t, "a b c", sanitize("a\tb\nc\r\x07"))
+	assert.Equal(t, "ab", sanitize("a\u009bb\xff"))
+	assert.Equal(t, "你好", sanitize("你好"))
+}
+
+func TestServer(t *testing.T) {
+	hub := chat.NewChatHub()
+	rm, err := hub.NewRoom("general", "")
+	assert.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := NewServer(hub, Options{})
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// web user
+	local, web := chat.Pipe()
+	hub.Connect(local)
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_JOIN, From: "web", Room: "general"}))
+
+	c := gatewaytest.Dial(t, ln.Addr().String(), "\n")
+	defer c.Close()
+	c.Expect("your name?")
+	c.Send("alice")
+	c.Expect("* hi alice")
+
+	c.Send("/nick bob")
+	c.Expect("! name can't be changed")
+	c.Send("hello?")
+	c.Expect("! no room joined")
+	c.Send("/rooms")
+	c.Expect("* 1 rooms:")
+	assert.Contains(t, c.Expect("general"), "*   general (")
+	c.Send("/join nope")
+	assert.Equal(t, "! room not found", c.Expect("!"))
+	c.Send("/join general")
+	c.Expect("* joined general")
+
+	c.Send(strings.Repeat("x", 600))
+	c.Expect("! message longer than 512 bytes")
+
+	// telnet to web
+	c.Send("hi from nc")
+	for {
+		msg, err := web.ReadMessage()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if msg.Type == chat.T_MESSAGE {
+			assert.Equal(t, "alice", msg.From)
+			assert.Equal(t, rm.ID, msg.Room)
+			assert.Equal(t, "hi from nc", msg.Data)
+			break
+		}
+	}
+
+	// web to telnet, read web messages so the pipe isn't blocked
+	go func() {
+		for {
+			if _, err := web.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_MESSAGE, From: "web", Room: rm.ID, Data: "hello\nnc"}))
+	assert.Equal(t, "[general] web: hello", c.Expect("web:"))
+	assert.Equal(t, "[general] web: nc", c.Expect("web:"))
+	assert.NoError(t, web.WriteMessage(&chat.Message{Type: chat.T_MESSAGE, From: "web", Room: rm.ID, Data: "\x1b]0;pwned\x07boom"}))
+	assert.Equal(t, "[general] web: boom", c.Expect("boom"))
+
+	// others of the same name are not taken for own messages
+	local2, web2 := chat.Pipe()
+	hub.Connect(local2)
+	go func() {
+		for {
+			if _, err := web2.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	assert.NoError(t, web2.WriteMessage(&chat.Message{Type: chat.T_JOIN, From: "alice", Room: "general"}))
+	assert.NoError(t, web2.WriteMessage(&chat.Message{Type: chat.T_MESSAGE, From: "alice", Room: rm.ID, Data: "same name"}))
+	assert.Equal(t, "[general] alice: same name", c.Expect("same name"))
+	web2.Close()
+	c.Expect("[general] * alice left")
+
+	c.Send("/foo")
+	c.Expect("! unknown command /foo")
+	web.Close()
+	c.Expect("[general] * web left")
+
+	c.Send("/leave")
+	c.Expect("* left general")
+	c.Send("/quit")
+	c.Expect("* bye")
+	assert.Eventually(t, func() bool {
+		return len(hub.Clients()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
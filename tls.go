@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how often certificate files are checked for changes
+	certCheckInterval = 10 * time.Second
+
+	// self-signed leaf certificate is renewed when it expires within this period
+	certRenewBefore = 30 * 24 * time.Hour
+)
+
+// CertLoader serves certificate loaded from files, and reloads it when
+// the files have been changed, so certificates can be renewed without
+// restarting the server.
+type CertLoader struct {
+	certFile, keyFile string
+
+	lck     sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // latest modification time of the files
+	checked time.Time // latest time the files have been checked
+}
+
+// NewCertLoader load certificate and key from PEM files
+func NewCertLoader(certFile, keyFile string) (*CertLoader, error) {
+	l := &CertLoader{certFile: certFile, keyFile: keyFile}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *CertLoader) modified() time.Time {
+	var mod time.Time
+	for _, file := range []string{l.certFile, l.keyFile} {
+		if fi, err := os.Stat(file); err == nil && fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	return mod
+}
+
+func (l *CertLoader) load() error {
+	mod := l.modified()
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return err
+	}
+	l.cert, l.modTime = &cert, mod
+	return nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (l *CertLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.lck.Lock()
+	defer l.lck.Unlock()
+
+	if now := time.Now(); now.Sub(l.checked) > certCheckInterval {
+		l.checked = now
+		if l.modified().After(l.modTime) {
+			if err := l.load(); err != nil {
+				// files may be half written, keep serving the old one
+				mainLog.Error("reload certificate failed", "cert", l.certFile, "err", err)
+			} else {
+				mainLog.Info("certificate reloaded", "cert", l.certFile)
+			}
+		}
+	}
+	return l.cert, nil
+}
+
+// TLSConfig return tls config serving certificates of the loader
+func (l *CertLoader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: l.GetCertificate,
+	}
+}
+
+// SelfSignedCert create a self-signed CA and a leaf certificate signed by it
+// for given hosts and ips in dir, and return the leaf certificate files.
+// The CA is cached, so browsers trusting it once keep trusting the server.
+// The leaf is renewed when it's about to expire or the hosts changed.
+func SelfSignedCert(dir string, hosts []string) (certFile, keyFile string, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+
+	ca, caKey, err := loadOrCreateCA(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"))
+	if err != nil {
+		return
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if leaf, e := loadCert(certFile); e == nil && leafValid(leaf, ca, hosts) {
+		return
+	}
+
+	mainLog.Info("create self-signed certificate", "hosts", strings.Join(hosts, ","))
+	err = createLeaf(ca, caKey, hosts, certFile, keyFile)
+	return
+}
+
+// CAFingerprint return SHA-256 fingerprint of the cached CA certificate
+func CAFingerprint(dir string) string {
+	ca, err := loadCert(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(ca.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func loadOrCreateCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	if ca, err := loadCert(certFile); err == nil {
+		key, err := loadKey(keyFile)
+		if err == nil && time.Now().Before(ca.NotAfter) {
+			return ca, key, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"sparrow"}, CommonName: "sparrow local CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = writePEM(keyFile, key, nil); err != nil {
+		return nil, nil, err
+	}
+	if err = writePEM(certFile, nil, der); err != nil {
+		return nil, nil, err
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+func createLeaf(ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string, certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"sparrow"}, CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	// write key first, the loader reloads when the cert file changes
+	if err = writePEM(keyFile, key, nil); err != nil {
+		return err
+	}
+	return writePEM(certFile, nil, der, ca.Raw)
+}
+
+// leafValid check leaf certificate is signed by ca, not expiring and covers
+// all hosts, a leaf of a replaced CA would not be trusted by clients.
+func leafValid(leaf, ca *x509.Certificate, hosts []string) bool {
+	if leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	if time.Now().Add(certRenewBefore).After(leaf.NotAfter) {
+		return false
+	}
+	for _, h := range hosts {
+		if leaf.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func loadCert(file string) (*x509.Certificate, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no certificate in " + file)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func loadKey(file string) (*ecdsa.PrivateKey, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, errors.New("no private key in " + file)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// writePEM write private key or certificate chain to file atomically
+func writePEM(file string, key *ecdsa.PrivateKey, certs ...[]byte) error {
+	var data []byte
+	if key != nil {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	}
+	for _, der := range certs {
+		if der != nil {
+			data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+		}
+	}
+
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// RedirectHTTPS redirect plain http requests to the https server at tlsAddr
+func RedirectHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
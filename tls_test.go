@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	hosts := []string{"localhost", "127.0.0.1", "192.168.1.10"}
+
+	certFile, keyFile, err := SelfSignedCert(dir, hosts)
+	assert.NoError(t, err)
+
+	loader, err := NewCertLoader(certFile, keyFile)
+	assert.NoError(t, err)
+	cert, err := loader.GetCertificate(nil)
+	assert.NoError(t, err)
+
+	// leaf is signed by the cached CA, and covers all hosts
+	ca, err := loadCert(filepath.Join(dir, "ca.pem"))
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	for _, h := range hosts {
+		_, err = leaf.Verify(x509.VerifyOptions{DNSName: h, Roots: pool})
+		assert.NoError(t, err, h)
+	}
+	assert.NotEmpty(t, CAFingerprint(dir))
+
+	// same hosts reuse the leaf, new hosts renew it with the same CA
+	same, _, err := SelfSignedCert(dir, hosts)
+	assert.NoError(t, err)
+	before, _ := ioutil.ReadFile(same)
+
+	_, _, err = SelfSignedCert(dir, append(hosts, "192.168.1.11"))
+	assert.NoError(t, err)
+	after, _ := ioutil.ReadFile(certFile)
+	assert.NotEqual(t, before, after)
+	renewed, err := loadCert(filepath.Join(dir, "ca.pem"))
+	assert.NoError(t, err)
+	assert.Equal(t, ca.Raw, renewed.Raw)
+
+	// loader picks up the renewed certificate
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	loader.checked = time.Time{}
+	cert, err = loader.GetCertificate(&tls.ClientHelloInfo{})
+	assert.NoError(t, err)
+	leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, leaf.VerifyHostname("192.168.1.11"))
+
+	// a new CA reissues the leaf even if it's still valid
+	assert.NoError(t, os.Remove(filepath.Join(dir, "ca.pem")))
+	_, _, err = SelfSignedCert(dir, hosts)
+	assert.NoError(t, err)
+	newCA, err := loadCert(filepath.Join(dir, "ca.pem"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, ca.Raw, newCA.Raw)
+	leaf, err = loadCert(certFile)
+	assert.NoError(t, err)
+	assert.NoError(t, leaf.CheckSignatureFrom(newCA))
+}